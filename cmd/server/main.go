@@ -6,13 +6,19 @@ import (
 	"go-caching-proxy/internal/admin"
 	"go-caching-proxy/internal/cache"
 	"go-caching-proxy/internal/config"
-	"go-caching-proxy/internal/middleware"
+	"go-caching-proxy/internal/key"
 	"go-caching-proxy/internal/metrics"
+	"go-caching-proxy/internal/middleware"
 	"go-caching-proxy/internal/proxy"
+	"go-caching-proxy/internal/route"
 	"go-caching-proxy/internal/server"
+	"go-caching-proxy/internal/upstream"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -24,18 +30,83 @@ func initCache(cfg *config.Config, logger *slog.Logger) (cache.Storer, error) {
 	switch cfg.Cache.CacheType {
 	case "redis":
 		logger.Info("initializing Redis cache")
-		return cache.NewRedisCache(cfg.Redis.Address, cfg.Redis.Password, cfg.Redis.DB)
-	
+		return cache.NewRedisCache(cfg.Redis.Address, cfg.Redis.Password, cfg.Redis.DB, cfg.Cache.ChunkBytes)
+
+	case "redis_ring":
+		logger.Info("initializing Redis ring cache", "nodes", len(cfg.RedisRing.Nodes))
+		return cache.NewRedisRingCache(ringNodes(cfg), cfg.RedisRing.VirtualNodes, cfg.Cache.ChunkBytes)
+
+	case "memcached":
+		logger.Info("initializing memcached cache", "servers", cfg.Memcached.Servers)
+		timeout := time.Duration(cfg.Memcached.TimeoutMS) * time.Millisecond
+		return cache.NewMemcachedCache(cfg.Memcached.Servers, cfg.Memcached.MaxItemBytes, timeout)
+
+	case "disk":
+		logger.Info("initializing disk cache", "path", cfg.Cache.Disk.Path, "max_bytes", cfg.Cache.Disk.MaxBytes)
+		disk, err := cache.NewDiskCache(cfg.Cache.Disk.Path, cfg.Cache.Disk.MaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Cache.Disk.MemoryFront {
+			return cache.NewTieredCache(cache.NewLRUCache(cfg.Cache.LRU.Size), disk), nil
+		}
+		return disk, nil
+
 	case "lru":
 		logger.Info("initializing LRU in-memory cache")
 		return cache.NewLRUCache(cfg.Cache.LRU.Size), nil
-	
+
 	default:
 		logger.Info("no cache_type specified, defaulting to LRU")
 		return cache.NewLRUCache(cfg.Cache.LRU.Size), nil
 	}
 }
 
+// ringNodes converts the configured ring nodes into the cache package's type.
+func ringNodes(cfg *config.Config) []cache.RingNode {
+	nodes := make([]cache.RingNode, 0, len(cfg.RedisRing.Nodes))
+	for _, n := range cfg.RedisRing.Nodes {
+		nodes = append(nodes, cache.RingNode{
+			Name:     n.Name,
+			Address:  n.Address,
+			Password: n.Password,
+			DB:       n.DB,
+			Weight:   n.Weight,
+		})
+	}
+	return nodes
+}
+
+// watchReload re-reads the configuration file every time the process receives
+// SIGHUP and applies the settings that can change at runtime.
+func watchReload(configPath string, appCache cache.Storer, encoder *key.Encoder, logger *slog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		cfg, err := config.Load(configPath)
+		if err != nil {
+			logger.Error("failed to reload configuration", "path", configPath, "error", err)
+			continue
+		}
+
+		if ring, ok := appCache.(*cache.RedisRingCache); ok {
+			if err := ring.SetNodes(ringNodes(cfg)); err != nil {
+				logger.Error("failed to rebalance redis ring", "error", err)
+			} else {
+				logger.Info("redis ring rebalanced", "nodes", ring.Nodes())
+			}
+		}
+		if gen := cfg.Cache.Generation; encoder.RaiseGeneration(gen) {
+			logger.Info("cache generation changed, existing entries invalidated", "generation", gen)
+		} else if gen < encoder.Generation() {
+			logger.Warn("cache generation in config is below the current one, ignored",
+				"config_generation", gen, "generation", encoder.Generation())
+		}
+		logger.Info("configuration reloaded")
+	}
+}
+
 func main() {
 	// --- 1. Initialization ---
 	configPath := flag.String("config", "configs/config.yaml", "Path to the configuration file")
@@ -61,8 +132,83 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Warm the in-memory cache from the last snapshot before accepting traffic
+	snapshotPath := cfg.Cache.Snapshot.Path
+	snapshotter, canSnapshot := appCache.(cache.Snapshotter)
+	if snapshotPath != "" && !canSnapshot {
+		logger.Warn("cache snapshots are not supported by this cache type; snapshot.path is ignored",
+			"cache_type", cfg.Cache.CacheType, "path", snapshotPath)
+	}
+	if snapshotPath != "" && canSnapshot {
+		n, err := cache.LoadSnapshot(snapshotPath, snapshotter)
+		if err != nil {
+			logger.Error("failed to restore cache snapshot", "path", snapshotPath, "error", err)
+		} else {
+			logger.Info("cache snapshot restored", "path", snapshotPath, "entries", n)
+		}
+	}
+
+	// Compile the cache key settings and the route table
+	keys, err := route.NewKeyBuilder(cfg.Cache.Key, nil)
+	if err != nil {
+		logger.Error("invalid cache key configuration", "error", err)
+		os.Exit(1)
+	}
+	routes, err := route.New(cfg.Routes, cfg.Cache.Key)
+	if err != nil {
+		logger.Error("invalid route configuration", "error", err)
+		os.Exit(1)
+	}
+	encoder, err := key.NewEncoder(cfg.Cache.Namespace, cfg.Cache.Generation, cfg.Cache.KeyHash)
+	if err != nil {
+		logger.Error("invalid cache key encoding", "error", err)
+		os.Exit(1)
+	}
+
+	purgeACL, err := proxy.NewPurgeACL(cfg.Purge.AllowedCIDRs, cfg.Purge.Token)
+	if err != nil {
+		logger.Error("invalid purge configuration", "error", err)
+		os.Exit(1)
+	}
+
+	negative, err := proxy.NewNegativeTTLs(cfg.Cache.Negative.TTLSeconds, cfg.Cache.Negative.TransportErrorSeconds)
+	if err != nil {
+		logger.Error("invalid negative cache configuration", "error", err)
+		os.Exit(1)
+	}
+
+	for _, u := range cfg.Upstreams {
+		if u.Transport.TLS.InsecureSkipVerify {
+			logger.Warn("TLS certificate verification is disabled; do not use in production", "upstream", u.Name)
+		}
+	}
+	if cfg.Proxy.Transport.TLS.InsecureSkipVerify {
+		logger.Warn("TLS certificate verification is disabled; do not use in production", "upstream", upstream.DefaultName)
+	}
+	upstreams, err := upstream.NewSet(cfg.Upstreams, cfg.Proxy.Upstream, encoder)
+	if err != nil {
+		logger.Error("invalid upstream configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Probe the backends and publish their health
+	healthChecker := upstream.NewHealthChecker(upstreams, logger, mets)
+	healthChecker.Start()
+
+	// Apply runtime-changeable settings on SIGHUP
+	go watchReload(*configPath, appCache, encoder, logger)
+
 	// Create the core proxy handler, injecting the cache
-	proxyHandler, err := proxy.NewHandler(cfg.Proxy.Target, appCache, cfg.GetDefaultTTL(), logger, mets)
+	proxyHandler, err := proxy.NewHandler(cfg.Proxy.Target, appCache, cfg.GetDefaultTTL(), logger, mets,
+		proxy.WithKeys(keys),
+		proxy.WithKeyEncoder(encoder),
+		proxy.WithUpstreams(upstreams),
+		proxy.WithPurgeACL(purgeACL),
+		proxy.WithMaxObjectBytes(cfg.Cache.MaxObjectBytes),
+		proxy.WithNegativeCaching(negative),
+		proxy.WithStale(time.Duration(cfg.Cache.StaleSeconds)*time.Second),
+		proxy.WithRoutes(routes),
+	)
 	if err != nil {
 		logger.Error("failed to create proxy handler", "error", err)
 		os.Exit(1)
@@ -77,6 +223,17 @@ func main() {
 	go func() {
 		adminMux := http.NewServeMux()
 		adminMux.Handle("/metrics", promhttp.Handler())
+		adminMux.HandleFunc("/health/upstreams", admin.UpstreamHealthHandler(proxyHandler))
+		// Endpoints that change the cache need the admin token; with none
+		// configured, middleware.Auth refuses every request.
+		adminMux.Handle("/cache/purge", middleware.Auth(cfg.Admin.Token, admin.PurgeTagHandler(proxyHandler)))
+		if cfg.Admin.Token != "" {
+			api := admin.NewAPI(appCache, proxyHandler, logger)
+			adminMux.Handle("/api/", middleware.Auth(cfg.Admin.Token, api.Handler()))
+		}
+		if snapshotPath != "" && canSnapshot {
+			adminMux.Handle("/cache/snapshot", middleware.Auth(cfg.Admin.Token, admin.SnapshotHandler(snapshotPath, snapshotter)))
+		}
 		adminPort := "9090"
 		logger.Info("starting admin server", "port", adminPort)
 		if err := http.ListenAndServe(":"+adminPort, adminMux); err != nil {
@@ -85,8 +242,19 @@ func main() {
 	}()
 
 	srv := server.New(cfg.Server.Port, logger)
+	srv.OnShutdown(healthChecker.Stop)
+	if snapshotPath != "" && canSnapshot {
+		srv.OnShutdown(func() {
+			n, err := cache.SaveSnapshot(snapshotPath, snapshotter)
+			if err != nil {
+				logger.Error("failed to save cache snapshot", "path", snapshotPath, "error", err)
+				return
+			}
+			logger.Info("cache snapshot saved", "path", snapshotPath, "entries", n)
+		})
+	}
 	if err := srv.Start(mainMux); err != nil {
 		logger.Error("main server failed to start", "error", err)
 		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
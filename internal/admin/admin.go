@@ -3,13 +3,121 @@ package admin
 
 import (
 	"encoding/json"
+	"go-caching-proxy/internal/cache"
+	"go-caching-proxy/internal/upstream"
 	"net/http"
 )
 
-// HealthzHandler returns a simple JSON response indicating the service is healthy.
-// In a real application, this could be expanded to check database connections or other dependencies.
+// UpstreamLister lists the proxy's upstreams. It is implemented by
+// proxy.Handler.
+type UpstreamLister interface {
+	Upstreams() []*upstream.Upstream
+}
+
+// HealthzHandler returns a simple JSON response indicating the process is
+// alive. It says nothing about the upstreams: cached responses are served
+// even when no backend is, so a load balancer must not pull the proxy over
+// them. See UpstreamHealthHandler.
 func HealthzHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
+
+// UpstreamHealthHandler returns a handler, for the admin port, reporting how
+// many backends of each upstream are in rotation. The status is "ok" when
+// all of them are, "degraded" when some are not, and "down", with a 503,
+// when no upstream has a healthy backend left.
+func UpstreamHealthHandler(p UpstreamLister) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		type counts struct {
+			Healthy int `json:"healthy"`
+			Total   int `json:"total"`
+		}
+		upstreams := make(map[string]counts)
+		healthy, total, serving := 0, 0, false
+		for _, up := range p.Upstreams() {
+			var c counts
+			for _, b := range up.Backends {
+				c.Total++
+				if b.Healthy() {
+					c.Healthy++
+				}
+			}
+			upstreams[up.Name] = c
+			healthy += c.Healthy
+			total += c.Total
+			serving = serving || c.Healthy > 0
+		}
+
+		status, code := "ok", http.StatusOK
+		switch {
+		case !serving:
+			status, code = "down", http.StatusServiceUnavailable
+		case healthy < total:
+			status = "degraded"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]any{"status": status, "upstreams": upstreams})
+	}
+}
+
+// SnapshotHandler returns a handler that saves the in-memory cache to path on
+// demand. It only accepts POST, since it writes to disk.
+func SnapshotHandler(path string, s cache.Snapshotter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		n, err := cache.SaveSnapshot(path, s)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{"status": "ok", "path": path, "entries": n})
+	}
+}
+
+// Purger removes cached entries by tag. It is implemented by proxy.Handler.
+type Purger interface {
+	PurgeTag(tag string) (int, error)
+}
+
+// PurgeTagHandler returns a handler that removes every entry carrying one of
+// the given tags, e.g. POST /cache/purge?tag=product-123&tag=product-456.
+func PurgeTagHandler(p Purger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+			return
+		}
+		tags := r.URL.Query()["tag"]
+		if len(tags) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "at least one tag is required"})
+			return
+		}
+
+		purged := make(map[string]int, len(tags))
+		for _, tag := range tags {
+			n, err := p.PurgeTag(tag)
+			if err != nil {
+				w.WriteHeader(http.StatusNotImplemented)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			purged[tag] = n
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{"status": "ok", "purged": purged})
+	}
+}
@@ -0,0 +1,379 @@
+// File: internal/admin/api.go
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-caching-proxy/internal/cache"
+	"go-caching-proxy/internal/key"
+	"go-caching-proxy/internal/proxy"
+	"go-caching-proxy/internal/upstream"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// API is the JSON admin API for inspecting and purging the cache:
+//
+//	GET    /api/keys?prefix=&after=&limit=   list keys, a page at a time
+//	GET    /api/entry?key=                   an entry's metadata
+//	DELETE /api/entry?key=                   delete one entry
+//	POST   /api/purge?prefix=|regex=|tag=    delete every matching entry
+//	POST   /api/flush                        delete every entry
+//	GET    /api/explain?url=&method=&header=&fetch=
+//	                                         why a request is (not) cached
+//	GET    /api/upstreams                    upstreams and backend state
+//	POST   /api/backend?upstream=&backend=&healthy=
+//	                                         eject or restore a backend
+//
+// Keys are relative to an upstream's namespace and the current generation,
+// i.e. exactly what the key template produced (or its hash, when keys are
+// hashed). The upstream query parameter picks the upstream; it defaults to
+// the default upstream, or the only one. A flush without it empties every
+// upstream's namespace. Listing and prefix or regex purges need a backend
+// implementing cache.Lister.
+type API struct {
+	cache  cache.Storer
+	proxy  Proxy
+	logger *slog.Logger
+}
+
+// Explainer reports how the proxy would treat a request. It is implemented
+// by proxy.Handler.
+type Explainer interface {
+	Explain(r *http.Request, fetch bool) *proxy.Explanation
+}
+
+// Proxy is what the API needs from the proxy handler.
+type Proxy interface {
+	Purger
+	Explainer
+
+	// Encoders returns the cache key encoder of every upstream, by name.
+	Encoders() map[string]*key.Encoder
+
+	// Upstreams returns every upstream, the default one last.
+	Upstreams() []*upstream.Upstream
+}
+
+// NewAPI creates the admin API. Key namespaces, tag purges and explanations
+// come from p.
+func NewAPI(c cache.Storer, p Proxy, logger *slog.Logger) *API {
+	return &API{
+		cache:  c,
+		proxy:  p,
+		logger: logger.With("component", "admin_api"),
+	}
+}
+
+// Handler returns the API's routes. It does no authentication of its own;
+// wrap it in middleware.Auth.
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/keys", a.listKeys)
+	mux.HandleFunc("GET /api/entry", a.getEntry)
+	mux.HandleFunc("DELETE /api/entry", a.deleteEntry)
+	mux.HandleFunc("POST /api/purge", a.purge)
+	mux.HandleFunc("POST /api/flush", a.flush)
+	mux.HandleFunc("/api/explain", a.explain)
+	mux.HandleFunc("GET /api/upstreams", a.listUpstreams)
+	mux.HandleFunc("POST /api/backend", a.setBackendHealth)
+	return mux
+}
+
+// entryInfo is the metadata reported for a single entry.
+type entryInfo struct {
+	Key        string      `json:"key"`
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers"`
+	Size       int         `json:"size"`
+	StoredAt   *time.Time  `json:"stored_at,omitempty"`
+	AgeSeconds *float64    `json:"age_seconds,omitempty"`
+	ExpiresAt  time.Time   `json:"expires_at"`
+	TTLSeconds float64     `json:"ttl_seconds"`
+	Hits       *int64      `json:"hits,omitempty"`
+	Tags       []string    `json:"tags,omitempty"`
+}
+
+func (a *API) listKeys(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := defaultPageSize
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, maxPageSize)
+	}
+
+	base, err := a.namespace(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	keys, ok := a.keys(base, q.Get("prefix"))
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "cache backend does not support listing keys")
+		return
+	}
+
+	// Pages are cursor based: "after" is the last key of the previous page.
+	after := q.Get("after")
+	start := sort.Search(len(keys), func(i int) bool { return keys[i] > after })
+	end := min(start+limit, len(keys))
+	page := keys[start:end]
+
+	resp := map[string]any{"keys": page, "total": len(keys)}
+	if end < len(keys) {
+		resp["next"] = page[len(page)-1]
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (a *API) getEntry(w http.ResponseWriter, r *http.Request) {
+	k := r.URL.Query().Get("key")
+	if k == "" {
+		writeError(w, http.StatusBadRequest, "key is required")
+		return
+	}
+	base, err := a.namespace(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	bk := base + k
+
+	// Read the hit count first, so our own Get isn't included in it.
+	var hits *int64
+	if hc, ok := a.cache.(cache.HitCounter); ok {
+		if n, ok := hc.Hits(bk); ok {
+			hits = &n
+		}
+	}
+	entry, found := a.cache.Get(bk)
+	if !found {
+		writeError(w, http.StatusNotFound, "no such entry")
+		return
+	}
+
+	now := time.Now()
+	info := entryInfo{
+		Key:        k,
+		StatusCode: entry.StatusCode,
+		Headers:    entry.Headers,
+		Size:       len(entry.Body),
+		ExpiresAt:  entry.ExpiresAt,
+		TTLSeconds: entry.ExpiresAt.Sub(now).Seconds(),
+		Hits:       hits,
+	}
+	if !entry.FreshUntil.IsZero() {
+		// Past this, the entry is kept stale until expires_at.
+		info.TTLSeconds = entry.FreshUntil.Sub(now).Seconds()
+	}
+	if !entry.StoredAt.IsZero() {
+		age := now.Sub(entry.StoredAt).Seconds()
+		info.StoredAt = &entry.StoredAt
+		info.AgeSeconds = &age
+	}
+	tagPrefix := base + "tag:"
+	for _, tag := range entry.Tags {
+		info.Tags = append(info.Tags, strings.TrimPrefix(tag, tagPrefix))
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (a *API) deleteEntry(w http.ResponseWriter, r *http.Request) {
+	k := r.URL.Query().Get("key")
+	if k == "" {
+		writeError(w, http.StatusBadRequest, "key is required")
+		return
+	}
+	base, err := a.namespace(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	a.cache.Delete(base + k)
+	a.logger.Info("deleted cache entry", "key", k)
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "key": k})
+}
+
+func (a *API) purge(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch {
+	case q.Has("tag"):
+		n, err := a.proxy.PurgeTag(q.Get("tag"))
+		if err != nil {
+			writeError(w, http.StatusNotImplemented, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "tag": q.Get("tag"), "purged": n})
+		return
+	}
+
+	base, err := a.namespace(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch {
+	case q.Has("regex"):
+		re, err := regexp.Compile(q.Get("regex"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid regex: "+err.Error())
+			return
+		}
+		a.deleteMatching(w, []string{base}, "", re.MatchString, "regex", q.Get("regex"))
+
+	case q.Has("prefix"):
+		a.deleteMatching(w, []string{base}, q.Get("prefix"), nil, "prefix", q.Get("prefix"))
+
+	default:
+		writeError(w, http.StatusBadRequest, "one of prefix, regex or tag is required")
+	}
+}
+
+func (a *API) flush(w http.ResponseWriter, r *http.Request) {
+	var bases []string
+	if r.URL.Query().Has("upstream") {
+		base, err := a.namespace(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		bases = []string{base}
+	} else {
+		for _, e := range a.proxy.Encoders() {
+			if !slices.Contains(bases, e.Prefix()) {
+				bases = append(bases, e.Prefix())
+			}
+		}
+	}
+	a.deleteMatching(w, bases, "", nil, "flush", "")
+}
+
+// namespace returns the key prefix of the upstream selected by the request.
+func (a *API) namespace(r *http.Request) (string, error) {
+	encoders := a.proxy.Encoders()
+	name := r.URL.Query().Get("upstream")
+	if name == "" {
+		name = upstream.DefaultName
+		if len(encoders) == 1 {
+			for only := range encoders {
+				name = only
+			}
+		}
+	}
+	e, ok := encoders[name]
+	if !ok {
+		return "", fmt.Errorf("unknown upstream %q", name)
+	}
+	return e.Prefix(), nil
+}
+
+// explain reports how the proxy treats the request described by the query:
+// url is the absolute URL as a client would request it, method defaults to
+// GET, each header parameter is a "Name: Value" request header, and
+// fetch=false skips the dry-run fetch from the origin. For a POST to this
+// endpoint, its body is used as the body of the explained request.
+func (a *API) explain(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	target, err := url.Parse(q.Get("url"))
+	if err != nil || target.Host == "" {
+		writeError(w, http.StatusBadRequest, "url must be an absolute URL")
+		return
+	}
+	method := q.Get("method")
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if r.Method == http.MethodPost {
+		body = r.Body
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), method, target.String(), body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Make it look like a request received by the proxy.
+	req.URL = &url.URL{Path: target.Path, RawPath: target.RawPath, RawQuery: target.RawQuery}
+	req.RequestURI = req.URL.RequestURI()
+	req.RemoteAddr = r.RemoteAddr
+	for _, h := range q["header"] {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("header %q must be \"Name: Value\"", h))
+			return
+		}
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	writeJSON(w, http.StatusOK, a.proxy.Explain(req, q.Get("fetch") != "false"))
+}
+
+// deleteMatching deletes the keys in the given namespaces that start with
+// prefix and match, if match is set, and reports how many were deleted.
+func (a *API) deleteMatching(w http.ResponseWriter, bases []string, prefix string, match func(string) bool, kind, pattern string) {
+	n := 0
+	for _, base := range bases {
+		keys, ok := a.keys(base, prefix)
+		if !ok {
+			writeError(w, http.StatusNotImplemented, "cache backend does not support listing keys")
+			return
+		}
+		for _, k := range keys {
+			if match == nil || match(k) {
+				a.cache.Delete(base + k)
+				n++
+			}
+		}
+	}
+	a.logger.Info("purged cache entries", "by", kind, "pattern", pattern, "entries", n)
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok", kind: pattern, "purged": n})
+}
+
+// keys lists the entries under the namespace prefix base starting with
+// prefix, relative to base and sorted. Tag sets stored in the same keyspace
+// are left out.
+func (a *API) keys(base, prefix string) ([]string, bool) {
+	lister, ok := a.cache.(cache.Lister)
+	if !ok {
+		return nil, false
+	}
+	keys := []string{}
+	for _, k := range lister.Keys(base + prefix) {
+		rel := strings.TrimPrefix(k, base)
+		if !strings.HasPrefix(rel, "tag:") {
+			keys = append(keys, rel)
+		}
+	}
+	sort.Strings(keys)
+	return keys, true
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
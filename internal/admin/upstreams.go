@@ -0,0 +1,81 @@
+// File: internal/admin/upstreams.go
+package admin
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// upstreamInfo is the state reported for one upstream.
+type upstreamInfo struct {
+	Name           string        `json:"name"`
+	Balance        string        `json:"balance,omitempty"`
+	CircuitBreaker string        `json:"circuit_breaker"`
+	Backends       []backendInfo `json:"backends"`
+}
+
+// backendInfo is the state reported for one backend.
+type backendInfo struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Weight   int    `json:"weight"`
+	Healthy  bool   `json:"healthy"`
+	InFlight int64  `json:"in_flight"`
+	// Why an unhealthy backend is out of rotation.
+	Disabled     bool       `json:"disabled,omitempty"`
+	Failing      bool       `json:"failing_health_checks,omitempty"`
+	EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+}
+
+func (a *API) listUpstreams(w http.ResponseWriter, r *http.Request) {
+	infos := []upstreamInfo{}
+	for _, up := range a.proxy.Upstreams() {
+		info := upstreamInfo{Name: up.Name, Balance: up.Balance, CircuitBreaker: up.Breaker.State().String()}
+		for _, b := range up.Backends {
+			bi := backendInfo{
+				Name:     b.Name(),
+				URL:      b.URL.String(),
+				Weight:   b.Weight,
+				Healthy:  b.Healthy(),
+				InFlight: b.InFlight(),
+				Disabled: b.Disabled(),
+				Failing:  b.Failing(),
+			}
+			if until := b.EjectedUntil(); !until.IsZero() {
+				bi.EjectedUntil = &until
+			}
+			info.Backends = append(info.Backends, bi)
+		}
+		infos = append(infos, info)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"upstreams": infos})
+}
+
+// setBackendHealth takes a backend out of rotation (healthy=false) or puts
+// it back, e.g. to drain it before maintenance. Putting it back doesn't
+// override failing health checks.
+func (a *API) setBackendHealth(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	healthy, err := strconv.ParseBool(q.Get("healthy"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "healthy must be true or false")
+		return
+	}
+	for _, up := range a.proxy.Upstreams() {
+		if up.Name != q.Get("upstream") {
+			continue
+		}
+		b, ok := up.Backend(q.Get("backend"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown backend "+strconv.Quote(q.Get("backend")))
+			return
+		}
+		if b.SetHealthy(healthy) {
+			a.logger.Info("backend health set", "upstream", up.Name, "backend", b.Name(), "healthy", healthy)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "upstream": up.Name, "backend": b.Name(), "healthy": healthy})
+		return
+	}
+	writeError(w, http.StatusNotFound, "unknown upstream "+strconv.Quote(q.Get("upstream")))
+}
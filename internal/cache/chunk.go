@@ -0,0 +1,180 @@
+// File: internal/cache/chunk.go
+package cache
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// DefaultChunkSize is the largest body stored inline with its entry. Bigger
+// bodies are split into chunks of this size.
+const DefaultChunkSize = 512 * 1024
+
+// blob is a single raw value to be written to a blobStore.
+type blob struct {
+	key   string
+	value []byte
+}
+
+// blobStore is the byte-level storage that chunking is built on. Backends
+// implement it with whatever batching their protocol offers (a Redis
+// pipeline, a memcached multi-key get) so that reading a chunked entry costs
+// two round trips regardless of its size.
+type blobStore interface {
+	// getMulti fetches several keys at once. Missing keys are absent from the
+	// result rather than an error.
+	getMulti(keys []string) (map[string][]byte, error)
+
+	// setMulti writes the blobs in order, all expiring at expiresAt.
+	setMulti(blobs []blob, expiresAt time.Time) error
+
+	// deleteMulti removes the given keys.
+	deleteMulti(keys []string) error
+}
+
+// chunkManifest is what gets stored under an entry's own key. Small entries
+// carry their body inline and have Chunks == 0. For large entries the body is
+// left out and stored in Chunks separate values under derived keys.
+//
+// CacheEntry is embedded so that entries written before chunking existed
+// (plain JSON-encoded CacheEntry values) still decode as inline manifests.
+//
+// Every write of a large entry picks a new Version, which names its chunks,
+// so that a reader can't pair a manifest with the chunks of another write.
+type chunkManifest struct {
+	CacheEntry
+	Chunks  int    `json:",omitempty"`
+	Version string `json:",omitempty"`
+}
+
+// chunker transparently splits large entries over several keys of a blobStore.
+type chunker struct {
+	store     blobStore
+	chunkSize int
+}
+
+func newChunker(store blobStore, chunkSize int) chunker {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return chunker{store: store, chunkSize: chunkSize}
+}
+
+// get reads an entry and reassembles its body. A missing chunk (evicted
+// independently of the manifest) is treated as a miss.
+func (c chunker) get(key string) (*CacheEntry, bool) {
+	m, ok := c.manifest(key)
+	if !ok {
+		return nil, false
+	}
+	if m.Chunks == 0 {
+		return &m.CacheEntry, true
+	}
+
+	keys := chunkKeys(key, m.Version, m.Chunks)
+	values, err := c.store.getMulti(keys)
+	if err != nil {
+		return nil, false
+	}
+	var body bytes.Buffer
+	for _, k := range keys {
+		chunk, ok := values[k]
+		if !ok {
+			return nil, false
+		}
+		body.Write(chunk)
+	}
+	m.Body = body.Bytes()
+	return &m.CacheEntry, true
+}
+
+// set writes an entry, chunking its body if it is larger than the chunk size,
+// and returns the manifest it replaced (without body), or nil. Chunks are
+// written before the manifest, so a concurrent reader never sees a manifest
+// whose chunks don't exist yet. The replaced entry's chunks are deleted
+// afterwards; a reader still holding its manifest gets a miss.
+func (c chunker) set(key string, entry CacheEntry) (*chunkManifest, error) {
+	old, _ := c.manifest(key)
+
+	var blobs []blob
+	m := chunkManifest{CacheEntry: entry}
+	if len(entry.Body) > c.chunkSize {
+		body := entry.Body
+		m.Chunks = (len(body) + c.chunkSize - 1) / c.chunkSize
+		m.Version = newChunkVersion()
+		m.Body = nil
+		for i, k := range chunkKeys(key, m.Version, m.Chunks) {
+			end := min((i+1)*c.chunkSize, len(body))
+			blobs = append(blobs, blob{key: k, value: body[i*c.chunkSize : end]})
+		}
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	blobs = append(blobs, blob{key: key, value: data})
+	if err := c.store.setMulti(blobs, entry.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	if old != nil && old.Chunks > 0 {
+		c.store.deleteMulti(chunkKeys(key, old.Version, old.Chunks))
+	}
+	return old, nil
+}
+
+// delete removes an entry together with all of its chunks. It returns the
+// removed entry's manifest (without body), or nil if there was no entry.
+func (c chunker) delete(key string) (*chunkManifest, error) {
+	keys := []string{key}
+	m, ok := c.manifest(key)
+	if ok {
+		keys = append(keys, chunkKeys(key, m.Version, m.Chunks)...)
+	}
+	if err := c.store.deleteMulti(keys); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// manifest reads and decodes the value stored under an entry's own key.
+func (c chunker) manifest(key string) (*chunkManifest, bool) {
+	values, err := c.store.getMulti([]string{key})
+	if err != nil {
+		return nil, false
+	}
+	data, ok := values[key]
+	if !ok {
+		return nil, false
+	}
+	var m chunkManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, false
+	}
+	return &m, true
+}
+
+// chunkKeys derives the keys holding the chunks of one write of an entry.
+// Entries written before chunks were versioned have an empty version.
+func chunkKeys(key, version string, n int) []string {
+	prefix := key + "#chunk:"
+	if version != "" {
+		prefix += version + ":"
+	}
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = prefix + strconv.Itoa(i)
+	}
+	return keys
+}
+
+// newChunkVersion returns a random version naming the chunks of one write.
+func newChunkVersion() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
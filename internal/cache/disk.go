@@ -0,0 +1,429 @@
+// File: internal/cache/disk.go
+package cache
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	diskEntryExt = ".entry"
+	diskTempExt  = ".tmp"
+)
+
+// DiskCache is a persistent cache that stores each entry as a file on local
+// disk, so large objects survive restarts and can exceed available RAM.
+// It fulfills the Storer interface.
+//
+// Every content file starts with a one-line JSON header (key, status, headers
+// and expiry) followed by the raw body. The in-memory index is rebuilt at
+// startup by reading only those headers, and files are always written to a
+// temporary name and renamed into place, so a crash never leaves a torn entry.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	ll    *list.List               // Usage order (front=most recent, back=least recent)
+	items map[string]*list.Element // Key -> index entry
+	tags  tagIndex                 // Tag -> keys, for PurgeTag
+	size  int64                    // Total bytes of all content files
+}
+
+// diskIndexEntry is the in-memory index record for one content file.
+type diskIndexEntry struct {
+	key       string
+	file      string
+	size      int64
+	expiresAt time.Time
+	tags      []string
+	hits      int64
+}
+
+// diskHeader is the metadata line at the start of every content file.
+type diskHeader struct {
+	Key        string      `json:"key"`
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers"`
+	ExpiresAt  time.Time   `json:"expires_at"`
+	StoredAt   time.Time   `json:"stored_at,omitzero"`
+	FreshUntil time.Time   `json:"fresh_until,omitzero"`
+	Tags       []string    `json:"tags,omitempty"`
+}
+
+// NewDiskCache opens (or creates) a disk cache in dir with a total byte budget.
+// Existing content files are indexed; expired, corrupt and half-written files
+// are removed.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if dir == "" {
+		return nil, errors.New("disk cache: directory is required")
+	}
+	if maxBytes <= 0 {
+		return nil, errors.New("disk cache: max_bytes must be positive")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	c := &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		tags:     make(tagIndex),
+	}
+	if err := c.rebuildIndex(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Get retrieves an entry from disk.
+func (c *DiskCache) Get(key string) (*CacheEntry, bool) {
+	c.mu.Lock()
+	elem, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, false
+	}
+	idx := elem.Value.(*diskIndexEntry)
+	if time.Now().After(idx.expiresAt) {
+		c.removeElement(elem)
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	idx.hits++
+	path := idx.file
+	c.mu.Unlock()
+
+	// Read outside the lock so slow disks don't serialize every lookup. If the
+	// file was evicted in the meantime, this is simply a miss.
+	hdr, body, err := readDiskFile(path, true)
+	if err != nil || hdr.Key != key {
+		return nil, false
+	}
+	return &CacheEntry{
+		StatusCode: hdr.StatusCode,
+		Headers:    hdr.Headers,
+		Body:       body,
+		ExpiresAt:  hdr.ExpiresAt,
+		StoredAt:   hdr.StoredAt,
+		FreshUntil: hdr.FreshUntil,
+		Tags:       hdr.Tags,
+	}, true
+}
+
+// Set writes an entry to disk and evicts least recently used entries until the
+// cache fits within its byte budget.
+func (c *DiskCache) Set(key string, entry CacheEntry) {
+	if time.Until(entry.ExpiresAt) <= 0 {
+		return // Already expired, don't cache.
+	}
+
+	path := c.pathFor(key)
+	hdr := diskHeader{
+		Key:        key,
+		StatusCode: entry.StatusCode,
+		Headers:    entry.Headers,
+		ExpiresAt:  entry.ExpiresAt,
+		StoredAt:   entry.StoredAt,
+		FreshUntil: entry.FreshUntil,
+		Tags:       entry.Tags,
+	}
+	tmp, size, err := writeDiskFile(c.dir, hdr, entry.Body)
+	if err != nil {
+		return // Don't cache if the write fails
+	}
+	defer os.Remove(tmp) // A no-op once renamed into place
+	if size > c.maxBytes {
+		return // Larger than the whole budget
+	}
+
+	// The slow write happened outside the lock; moving the file into place
+	// and indexing it happen under it, so that a concurrent Set, Delete or
+	// PurgeTag of the same key can't leave the index out of step with the
+	// file.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(tmp, path); err != nil {
+		return
+	}
+
+	if elem, ok := c.items[key]; ok {
+		idx := elem.Value.(*diskIndexEntry)
+		c.size += size - idx.size
+		c.tags.remove(key, idx.tags)
+		idx.size = size
+		idx.expiresAt = entry.ExpiresAt
+		idx.tags = entry.Tags
+		idx.hits = 0
+		c.ll.MoveToFront(elem)
+	} else {
+		c.items[key] = c.ll.PushFront(&diskIndexEntry{
+			key:       key,
+			file:      path,
+			size:      size,
+			expiresAt: entry.ExpiresAt,
+			tags:      entry.Tags,
+		})
+		c.size += size
+	}
+	c.tags.add(key, entry.Tags)
+
+	for c.size > c.maxBytes {
+		c.evict()
+	}
+}
+
+// Delete removes an entry and its content file.
+func (c *DiskCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// PurgeTag removes every entry carrying the tag, along with its file.
+func (c *DiskCache) PurgeTag(tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := c.tags.keys(tag)
+	for _, key := range keys {
+		c.removeElement(c.items[key])
+	}
+	return len(keys)
+}
+
+// Tags returns the tags in use that start with prefix.
+func (c *DiskCache) Tags(prefix string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tags.withPrefix(prefix)
+}
+
+// Keys returns the unexpired keys starting with prefix.
+func (c *DiskCache) Keys(prefix string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	var keys []string
+	for key, elem := range c.items {
+		if strings.HasPrefix(key, prefix) && now.Before(elem.Value.(*diskIndexEntry).expiresAt) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Hits returns how many times the entry has been read since it was written.
+// Counts start from zero again after a restart.
+func (c *DiskCache) Hits(key string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return 0, false
+	}
+	return elem.Value.(*diskIndexEntry).hits, true
+}
+
+// Size returns the total number of bytes currently stored on disk.
+func (c *DiskCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// pathFor maps a key to its content file. Keys are hashed because they contain
+// characters that are not valid in file names and can be arbitrarily long.
+func (c *DiskCache) pathFor(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+diskEntryExt)
+}
+
+// evict removes the least recently used entry. Must be called with the lock held.
+func (c *DiskCache) evict() {
+	if elem := c.ll.Back(); elem != nil {
+		c.removeElement(elem)
+	}
+}
+
+// removeElement drops an entry from the index and deletes its file.
+// Must be called with the lock held.
+func (c *DiskCache) removeElement(elem *list.Element) {
+	idx := elem.Value.(*diskIndexEntry)
+	c.ll.Remove(elem)
+	delete(c.items, idx.key)
+	c.tags.remove(idx.key, idx.tags)
+	c.size -= idx.size
+	os.Remove(idx.file)
+}
+
+// rebuildIndex scans the cache directory and indexes every valid content file.
+// Files are ordered by modification time, oldest at the back, which gives a
+// reasonable approximation of the recency order before the restart.
+func (c *DiskCache) rebuildIndex() error {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type found struct {
+		idx     *diskIndexEntry
+		modTime time.Time
+	}
+	var files []found
+	now := time.Now()
+
+	for _, de := range dirEntries {
+		path := filepath.Join(c.dir, de.Name())
+		switch {
+		case strings.HasSuffix(de.Name(), diskTempExt):
+			os.Remove(path) // Left behind by a crash mid-write
+			continue
+		case !strings.HasSuffix(de.Name(), diskEntryExt):
+			continue
+		}
+
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		hdr, _, err := readDiskFile(path, false)
+		if err != nil || path != c.pathFor(hdr.Key) || now.After(hdr.ExpiresAt) {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, found{
+			idx: &diskIndexEntry{
+				key:       hdr.Key,
+				file:      path,
+				size:      info.Size(),
+				expiresAt: hdr.ExpiresAt,
+				tags:      hdr.Tags,
+			},
+			modTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	for _, f := range files {
+		c.items[f.idx.key] = c.ll.PushBack(f.idx)
+		c.tags.add(f.idx.key, f.idx.tags)
+		c.size += f.idx.size
+	}
+	for c.size > c.maxBytes {
+		c.evict()
+	}
+	return nil
+}
+
+// writeDiskFile writes a content file to a temporary file in dir, to be
+// renamed into place by the caller, and returns its name and size.
+func writeDiskFile(dir string, hdr diskHeader, body []byte) (string, int64, error) {
+	var size int64
+	tmp, err := writeTempFile(dir, func(w io.Writer) error {
+		line, err := json.Marshal(hdr)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+		if _, err := w.Write(body); err != nil {
+			return err
+		}
+		size = int64(len(line) + len(body))
+		return nil
+	})
+	return tmp, size, err
+}
+
+// readDiskFile reads the header of a content file and, if withBody is set,
+// the body that follows it.
+func readDiskFile(path string, withBody bool) (diskHeader, []byte, error) {
+	var hdr diskHeader
+
+	f, err := os.Open(path)
+	if err != nil {
+		return hdr, nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return hdr, nil, err
+	}
+	if err := json.Unmarshal(line, &hdr); err != nil {
+		return hdr, nil, err
+	}
+	if !withBody {
+		return hdr, nil, nil
+	}
+	body, err := io.ReadAll(r)
+	return hdr, body, err
+}
+
+// writeFileAtomic writes a file by filling a temporary file in the same
+// directory, syncing it, and renaming it over the destination. Readers see
+// either the old file or the complete new one, never a partial write.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := writeTempFile(filepath.Dir(path), write)
+	if err != nil {
+		return err
+	}
+	// Clean up the temporary file if the rename fails; after a successful
+	// rename this is a harmless no-op.
+	defer os.Remove(tmp)
+	return os.Rename(tmp, path)
+}
+
+// writeTempFile fills and syncs a new temporary file in dir and returns its
+// name. On failure nothing is left behind.
+func writeTempFile(dir string, write func(w io.Writer) error) (string, error) {
+	tmp, err := os.CreateTemp(dir, "*"+diskTempExt)
+	if err != nil {
+		return "", err
+	}
+	fail := func(err error) (string, error) {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	w := bufio.NewWriter(tmp)
+	if err := write(w); err != nil {
+		return fail(err)
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -2,6 +2,7 @@
 package cache
 
 import (
+	"io"
 	"net/http"
 	"time"
 )
@@ -14,6 +15,25 @@ type CacheEntry struct {
 	Headers    http.Header
 	Body       []byte
 	ExpiresAt  time.Time
+
+	// StoredAt is when the response was cached, for reporting its age.
+	StoredAt time.Time `json:",omitzero"`
+
+	// FreshUntil, when set, ends the entry's freshness before ExpiresAt.
+	// In between the entry is stale: it is no longer served as a hit, but
+	// kept for when the origin can't be reached.
+	FreshUntil time.Time `json:",omitzero"`
+
+	// Tags are the surrogate keys (cache tags) the entry can be purged by.
+	Tags []string `json:",omitempty"`
+}
+
+// Fresh reports whether the entry may be served as a cache hit at now.
+func (e *CacheEntry) Fresh(now time.Time) bool {
+	if !e.FreshUntil.IsZero() {
+		return now.Before(e.FreshUntil)
+	}
+	return now.Before(e.ExpiresAt)
 }
 
 // Storer is the interface that defines the contract for all cache implementations.
@@ -24,7 +44,45 @@ type Storer interface {
 
 	// Set stores a CacheEntry with a given key.
 	Set(key string, entry CacheEntry)
-	
+
 	// Delete removes an entry from the cache.
 	Delete(key string)
-}
\ No newline at end of file
+}
+
+// Snapshotter is implemented by in-process caches whose contents can be saved
+// to a stream and loaded back, so a restart doesn't begin with an empty cache.
+type Snapshotter interface {
+	// Snapshot writes every live entry to w and returns how many were written.
+	Snapshot(w io.Writer) (int, error)
+
+	// Restore loads entries written by Snapshot, skipping any that have
+	// expired in the meantime, and returns how many were loaded.
+	Restore(r io.Reader) (int, error)
+}
+
+// Tagger is implemented by caches that index entries by tag, so that every
+// entry carrying a tag can be removed in one call.
+type Tagger interface {
+	// PurgeTag removes every entry carrying the tag and returns how many
+	// entries were removed.
+	PurgeTag(tag string) int
+
+	// Tags returns the tags currently in use that start with prefix.
+	Tags(prefix string) []string
+}
+
+// Lister is implemented by caches that can enumerate their keys. The admin
+// API uses it to browse the cache and to purge by prefix or pattern.
+type Lister interface {
+	// Keys returns the keys of the live entries starting with prefix, in no
+	// particular order.
+	Keys(prefix string) []string
+}
+
+// HitCounter is implemented by caches that count how often each entry is
+// served.
+type HitCounter interface {
+	// Hits returns how many times Get has found the entry since it was
+	// stored, and false if there is no such entry.
+	Hits(key string) (int64, bool)
+}
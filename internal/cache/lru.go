@@ -3,6 +3,7 @@ package cache
 
 import (
 	"container/list"
+	"strings"
 	"sync"
 	"time"
 )
@@ -11,8 +12,9 @@ import (
 // It fulfills the Storer interface.
 type LRUCache struct {
 	maxSize int
-	ll      *list.List // Doubly-linked list to track usage order (front=most recent, back=least recent)
+	ll      *list.List               // Doubly-linked list to track usage order (front=most recent, back=least recent)
 	items   map[string]*list.Element // Map for fast key-based lookups
+	tags    tagIndex                 // Tag -> keys, for PurgeTag
 	mu      sync.Mutex
 }
 
@@ -21,6 +23,7 @@ type LRUCache struct {
 type lruEntry struct {
 	key   string
 	value CacheEntry
+	hits  int64
 }
 
 // NewLRUCache creates a new LRUCache with a given size.
@@ -32,6 +35,7 @@ func NewLRUCache(size int) *LRUCache {
 		maxSize: size,
 		ll:      list.New(),
 		items:   make(map[string]*list.Element),
+		tags:    make(tagIndex),
 	}
 }
 
@@ -43,7 +47,11 @@ func (c *LRUCache) Set(key string, value CacheEntry) {
 	// If the item already exists, update its value and move it to the front.
 	if elem, ok := c.items[key]; ok {
 		c.ll.MoveToFront(elem)
-		elem.Value.(*lruEntry).value = value
+		entry := elem.Value.(*lruEntry)
+		c.tags.remove(key, entry.value.Tags)
+		entry.value = value
+		entry.hits = 0
+		c.tags.add(key, value.Tags)
 		return
 	}
 
@@ -55,6 +63,7 @@ func (c *LRUCache) Set(key string, value CacheEntry) {
 	// Add the new item to the front of the list and to the map.
 	newElem := c.ll.PushFront(&lruEntry{key: key, value: value})
 	c.items[key] = newElem
+	c.tags.add(key, value.Tags)
 }
 
 // Get retrieves a value by its key.
@@ -67,18 +76,19 @@ func (c *LRUCache) Get(key string) (*CacheEntry, bool) {
 		return nil, false
 	}
 
-	entry := elem.Value.(*lruEntry).value
+	e := elem.Value.(*lruEntry)
+	entry := e.value
 
 	// Check for TTL expiration. This is "lazy eviction".
 	if time.Now().After(entry.ExpiresAt) {
 		// Item expired, remove it and report a miss.
-		c.ll.Remove(elem)
-		delete(c.items, key)
+		c.removeElement(elem)
 		return nil, false
 	}
 
 	// This item was just accessed, so move it to the front to mark it as most recently used.
 	c.ll.MoveToFront(elem)
+	e.hits++
 	return &entry, true
 }
 
@@ -88,17 +98,69 @@ func (c *LRUCache) Delete(key string) {
 	defer c.mu.Unlock()
 
 	if elem, ok := c.items[key]; ok {
-		c.ll.Remove(elem)
-		delete(c.items, key)
+		c.removeElement(elem)
+	}
+}
+
+// PurgeTag removes every entry carrying the tag.
+func (c *LRUCache) PurgeTag(tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := c.tags.keys(tag)
+	for _, key := range keys {
+		c.removeElement(c.items[key])
 	}
+	return len(keys)
+}
+
+// Tags returns the tags in use that start with prefix.
+func (c *LRUCache) Tags(prefix string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tags.withPrefix(prefix)
+}
+
+// Keys returns the unexpired keys starting with prefix.
+func (c *LRUCache) Keys(prefix string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	var keys []string
+	for key, elem := range c.items {
+		if strings.HasPrefix(key, prefix) && now.Before(elem.Value.(*lruEntry).value.ExpiresAt) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Hits returns how many times the entry has been served from the cache.
+func (c *LRUCache) Hits(key string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return 0, false
+	}
+	return elem.Value.(*lruEntry).hits, true
 }
 
 // evict removes the least recently used item. Must be called with the lock held.
 func (c *LRUCache) evict() {
 	elem := c.ll.Back()
 	if elem != nil {
-		c.ll.Remove(elem)
-		entry := elem.Value.(*lruEntry)
-		delete(c.items, entry.key)
+		c.removeElement(elem)
 	}
-}
\ No newline at end of file
+}
+
+// removeElement drops an item from the list, the map and the tag index.
+// Must be called with the lock held.
+func (c *LRUCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*lruEntry)
+	c.ll.Remove(elem)
+	delete(c.items, entry.key)
+	c.tags.remove(entry.key, entry.value.Tags)
+}
@@ -0,0 +1,307 @@
+// File: internal/cache/memcached.go
+package cache
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-caching-proxy/internal/hashring"
+)
+
+const (
+	// DefaultMemcachedItemSize is memcached's default maximum item size (-I).
+	DefaultMemcachedItemSize = 1 << 20
+
+	// memcachedMaxRelativeTTL is the largest expiry memcached treats as a
+	// relative number of seconds; larger values are read as Unix timestamps.
+	memcachedMaxRelativeTTL = 30 * 24 * time.Hour
+
+	memcachedMaxIdleConns = 8
+
+	// memcachedItemOverhead is the room reserved in each item for the
+	// encoded status line and headers of an entry.
+	memcachedItemOverhead = 16 * 1024
+
+	// MinMemcachedItemSize is the smallest item size limit that leaves room
+	// for a useful chunk after the overhead.
+	MinMemcachedItemSize = 32 * 1024
+)
+
+// MemcachedCache is a cache implementation that speaks the memcached text
+// protocol to one or more servers, spreading keys over them with a
+// consistent-hash ring. Entries larger than the server's item size limit are
+// split into chunks (see chunker). It satisfies the Storer interface.
+type MemcachedCache struct {
+	ring      *hashring.Ring
+	servers   map[string]*memcachedServer
+	chunkSize int
+}
+
+// NewMemcachedCache creates a cache over the given "host:port" servers.
+// itemSize is the servers' maximum item size (0 uses the 1MB default), and
+// timeout bounds every network operation. Item sizes below
+// MinMemcachedItemSize are rejected. Like NewRedisCache, it checks that
+// every server is reachable before returning.
+func NewMemcachedCache(servers []string, itemSize int, timeout time.Duration) (*MemcachedCache, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("memcached: at least one server is required")
+	}
+	if itemSize <= 0 {
+		itemSize = DefaultMemcachedItemSize
+	}
+	if itemSize < MinMemcachedItemSize {
+		return nil, fmt.Errorf("memcached: item size %d is below the minimum of %d bytes", itemSize, MinMemcachedItemSize)
+	}
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	c := &MemcachedCache{
+		ring:    hashring.New(0),
+		servers: make(map[string]*memcachedServer, len(servers)),
+		// Inline bodies are base64-encoded inside the JSON manifest, which
+		// grows them by a third; leave room for that and for the headers.
+		chunkSize: itemSize*3/4 - memcachedItemOverhead,
+	}
+	for _, addr := range servers {
+		srv := &memcachedServer{addr: addr, timeout: timeout}
+		if err := srv.ping(); err != nil {
+			return nil, fmt.Errorf("memcached: %s: %w", addr, err)
+		}
+		c.servers[addr] = srv
+		c.ring.Add(addr, 1)
+	}
+	return c, nil
+}
+
+// Get retrieves an entry, reassembling it from its chunks if necessary.
+// A missing chunk is treated as a miss.
+func (c *MemcachedCache) Get(key string) (*CacheEntry, bool) {
+	mk := memcachedKey(key)
+	return c.chunkerFor(mk).get(mk)
+}
+
+// Set stores an entry, chunking the body if it doesn't fit in one item.
+func (c *MemcachedCache) Set(key string, entry CacheEntry) {
+	if time.Until(entry.ExpiresAt) <= 0 {
+		return // Already expired, don't cache.
+	}
+	mk := memcachedKey(key)
+	c.chunkerFor(mk).set(mk, entry)
+}
+
+// Delete removes an entry and its chunks.
+func (c *MemcachedCache) Delete(key string) {
+	mk := memcachedKey(key)
+	c.chunkerFor(mk).delete(mk)
+}
+
+// chunkerFor returns a chunker over the server that owns the key. Chunks are
+// stored on the same server as their manifest, so they can be fetched with a
+// single multi-key get.
+func (c *MemcachedCache) chunkerFor(mk string) chunker {
+	return newChunker(c.servers[c.ring.Get(mk)], c.chunkSize)
+}
+
+// memcachedKey maps a cache key to a memcached key. Memcached keys are limited
+// to 250 bytes without spaces or control characters, so keys are hashed.
+func memcachedKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "gocache:" + hex.EncodeToString(sum[:])
+}
+
+// memcachedExpiry converts an expiry time to memcached's exptime format.
+func memcachedExpiry(expiresAt time.Time) int64 {
+	ttl := time.Until(expiresAt)
+	if ttl > memcachedMaxRelativeTTL {
+		return expiresAt.Unix()
+	}
+	// Round up so that a TTL under one second doesn't become 0 ("never").
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+// memcachedServer is a single memcached server with a small pool of idle
+// connections.
+type memcachedServer struct {
+	addr    string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	idle []*memcachedConn
+}
+
+type memcachedConn struct {
+	nc net.Conn
+	rw *bufio.ReadWriter
+}
+
+// do runs fn on a pooled connection. Connections that saw an error are closed
+// rather than returned to the pool, since their protocol state is unknown.
+func (s *memcachedServer) do(fn func(rw *bufio.ReadWriter) error) error {
+	conn, err := s.conn()
+	if err != nil {
+		return err
+	}
+	conn.nc.SetDeadline(time.Now().Add(s.timeout))
+
+	err = fn(conn.rw)
+	if err != nil {
+		conn.nc.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	if len(s.idle) < memcachedMaxIdleConns {
+		s.idle = append(s.idle, conn)
+		conn = nil
+	}
+	s.mu.Unlock()
+	if conn != nil {
+		conn.nc.Close()
+	}
+	return nil
+}
+
+func (s *memcachedServer) conn() (*memcachedConn, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		conn := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return conn, nil
+	}
+	s.mu.Unlock()
+
+	nc, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &memcachedConn{
+		nc: nc,
+		rw: bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc)),
+	}, nil
+}
+
+// ping checks that the server answers a "version" command.
+func (s *memcachedServer) ping() error {
+	return s.do(func(rw *bufio.ReadWriter) error {
+		if _, err := rw.WriteString("version\r\n"); err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		line, err := readMemcachedLine(rw.Reader)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "VERSION") {
+			return fmt.Errorf("unexpected reply %q", line)
+		}
+		return nil
+	})
+}
+
+// getMulti implements blobStore: it fetches one or more keys in a single
+// request. Keys that are not present are simply absent from the result.
+func (s *memcachedServer) getMulti(keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(keys))
+	err := s.do(func(rw *bufio.ReadWriter) error {
+		if _, err := rw.WriteString("get " + strings.Join(keys, " ") + "\r\n"); err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		for {
+			line, err := readMemcachedLine(rw.Reader)
+			if err != nil {
+				return err
+			}
+			if line == "END" {
+				return nil
+			}
+			// VALUE <key> <flags> <bytes>
+			fields := strings.Fields(line)
+			if len(fields) < 4 || fields[0] != "VALUE" {
+				return fmt.Errorf("memcached: unexpected reply %q", line)
+			}
+			size, err := strconv.Atoi(fields[3])
+			if err != nil {
+				return fmt.Errorf("memcached: bad value size %q", fields[3])
+			}
+			buf := make([]byte, size+2) // Data plus trailing \r\n
+			if _, err := io.ReadFull(rw, buf); err != nil {
+				return err
+			}
+			values[fields[1]] = buf[:size]
+		}
+	})
+	return values, err
+}
+
+// setMulti implements blobStore: it pipelines one "set" per blob and then
+// reads all the replies.
+func (s *memcachedServer) setMulti(blobs []blob, expiresAt time.Time) error {
+	exp := memcachedExpiry(expiresAt)
+	return s.do(func(rw *bufio.ReadWriter) error {
+		for _, b := range blobs {
+			fmt.Fprintf(rw, "set %s 0 %d %d\r\n", b.key, exp, len(b.value))
+			rw.Write(b.value)
+			rw.WriteString("\r\n")
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		return readMemcachedReplies(rw.Reader, len(blobs), "STORED")
+	})
+}
+
+// deleteMulti implements blobStore. A missing key is not an error.
+func (s *memcachedServer) deleteMulti(keys []string) error {
+	return s.do(func(rw *bufio.ReadWriter) error {
+		for _, k := range keys {
+			rw.WriteString("delete " + k + "\r\n")
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		return readMemcachedReplies(rw.Reader, len(keys), "DELETED", "NOT_FOUND")
+	})
+}
+
+// readMemcachedReplies reads n single-line replies and returns an error for
+// the first one that isn't in ok. All n replies are always consumed so the
+// connection stays in sync.
+func readMemcachedReplies(r *bufio.Reader, n int, ok ...string) error {
+	var failure error
+	for i := 0; i < n; i++ {
+		line, err := readMemcachedLine(r)
+		if err != nil {
+			return err
+		}
+		if failure == nil && !slices.Contains(ok, line) {
+			failure = fmt.Errorf("memcached: %s", line)
+		}
+	}
+	return failure
+}
+
+// readMemcachedLine reads one protocol line without its \r\n terminator.
+func readMemcachedLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
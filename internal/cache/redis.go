@@ -3,7 +3,8 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -11,13 +12,20 @@ import (
 
 // RedisCache is a cache implementation that uses Redis as the backend.
 // It satisfies the Storer interface.
+//
+// Entries are stored as JSON. Bodies larger than the chunk size are split
+// over several keys and read back with a single pipelined round trip, so
+// multi-megabyte artifacts don't end up as one huge Redis string.
 type RedisCache struct {
-	client *redis.Client
-	ctx    context.Context
+	client  *redis.Client
+	ctx     context.Context
+	chunker chunker
 }
 
 // NewRedisCache creates a new connection to Redis and returns a RedisCache.
-func NewRedisCache(addr, password string, db int) (*RedisCache, error) {
+// chunkSize is the largest body stored as a single value (0 uses
+// DefaultChunkSize).
+func NewRedisCache(addr, password string, db, chunkSize int) (*RedisCache, error) {
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
@@ -30,51 +38,179 @@ func NewRedisCache(addr, password string, db int) (*RedisCache, error) {
 		return nil, err
 	}
 
-	return &RedisCache{
+	return newRedisCacheFromClient(rdb, chunkSize), nil
+}
+
+// newRedisCacheFromClient wraps an existing client without pinging it. It is
+// used by RedisRingCache, which must tolerate individual nodes being down.
+func newRedisCacheFromClient(rdb *redis.Client, chunkSize int) *RedisCache {
+	c := &RedisCache{
 		client: rdb,
-		ctx:    ctx,
-	}, nil
+		ctx:    context.Background(),
+	}
+	c.chunker = newChunker(c, chunkSize)
+	return c
 }
 
-// Get retrieves an entry from Redis.
+// Get retrieves an entry from Redis. Missing chunks and connection errors are
+// reported as misses.
 func (c *RedisCache) Get(key string) (*CacheEntry, bool) {
-	// Fetch the value (which is a JSON string) from Redis.
-	val, err := c.client.Get(c.ctx, key).Result()
-	if err == redis.Nil {
-		return nil, false // Cache miss
-	} else if err != nil {
-		return nil, false // Some other error
+	// We don't need to check TTL here, as Redis's `Set` command handles expiration for us.
+	return c.chunker.get(key)
+}
+
+// Set stores an entry in Redis. Each of the entry's tags is a Redis set of
+// the keys carrying it, kept alive for at least as long as its longest-lived
+// member. The key is taken out of the sets of the tags the entry it replaces
+// had but it no longer has.
+func (c *RedisCache) Set(key string, entry CacheEntry) {
+	ttl := time.Until(entry.ExpiresAt)
+	if ttl <= 0 {
+		return // Already expired, don't cache.
+	}
+	old, err := c.chunker.set(key, entry)
+	if err != nil {
+		return
 	}
+	var dropped []string
+	if old != nil {
+		for _, tag := range old.Tags {
+			if !slices.Contains(entry.Tags, tag) {
+				dropped = append(dropped, tag)
+			}
+		}
+	}
+	if len(entry.Tags) == 0 && len(dropped) == 0 {
+		return
+	}
+
+	c.client.Pipelined(c.ctx, func(p redis.Pipeliner) error {
+		for _, tag := range dropped {
+			p.SRem(c.ctx, tag, key)
+		}
+		for _, tag := range entry.Tags {
+			p.SAdd(c.ctx, tag, key)
+			// NX gives a new set a TTL; GT only ever extends an existing one.
+			p.ExpireNX(c.ctx, tag, ttl)
+			p.ExpireGT(c.ctx, tag, ttl)
+		}
+		return nil
+	})
+}
+
+// Delete removes an entry, and any chunks it was split into, from Redis.
+func (c *RedisCache) Delete(key string) {
+	c.deleteEntry(key)
+}
 
-	// Value found, deserialize the JSON string back into a CacheEntry struct.
-	var entry CacheEntry
-	if err := json.Unmarshal([]byte(val), &entry); err != nil {
-		return nil, false // Failed to parse the data
+// PurgeTag removes every entry in the tag's set, then the set itself.
+func (c *RedisCache) PurgeTag(tag string) int {
+	keys, err := c.client.SMembers(c.ctx, tag).Result()
+	if err != nil {
+		return 0
 	}
+	n := 0
+	for _, key := range keys {
+		if c.deleteEntry(key) {
+			n++
+		}
+	}
+	c.client.Del(c.ctx, tag)
+	return n
+}
 
-	// We don't need to check TTL here, as Redis's `Set` command handles expiration for us.
-	return &entry, true
+// Tags finds the tag sets starting with prefix using SCAN, so that large
+// keyspaces are walked incrementally rather than blocking Redis. Tags live in
+// the same keyspace as entries, so prefix must be one only tags can have.
+func (c *RedisCache) Tags(prefix string) []string {
+	var tags []string
+	iter := c.client.Scan(c.ctx, 0, globEscape(prefix)+"*", 1000).Iterator()
+	for iter.Next(c.ctx) {
+		tags = append(tags, iter.Val())
+	}
+	return tags
 }
 
-// Set stores an entry in Redis.
-func (c *RedisCache) Set(key string, entry CacheEntry) {
-	// Serialize the Go struct into a JSON string.
-	data, err := json.Marshal(entry)
+// Keys finds the entries starting with prefix using SCAN. The chunks of large
+// entries are skipped, but tag sets sharing the prefix are not.
+func (c *RedisCache) Keys(prefix string) []string {
+	var keys []string
+	iter := c.client.Scan(c.ctx, 0, globEscape(prefix)+"*", 1000).Iterator()
+	for iter.Next(c.ctx) {
+		if !strings.Contains(iter.Val(), "#chunk:") {
+			keys = append(keys, iter.Val())
+		}
+	}
+	return keys
+}
+
+// deleteEntry removes an entry and its chunks, and takes it out of the sets
+// of its tags. It reports whether the entry existed.
+func (c *RedisCache) deleteEntry(key string) bool {
+	m, err := c.chunker.delete(key)
+	if err != nil || m == nil {
+		return false
+	}
+	if len(m.Tags) > 0 {
+		c.client.Pipelined(c.ctx, func(p redis.Pipeliner) error {
+			for _, tag := range m.Tags {
+				p.SRem(c.ctx, tag, key)
+			}
+			return nil
+		})
+	}
+	return true
+}
+
+// Close releases the underlying connection pool.
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
+
+// globEscape escapes the characters that are special in Redis MATCH patterns.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// getMulti implements blobStore with a single MGET.
+func (c *RedisCache) getMulti(keys []string) (map[string][]byte, error) {
+	vals, err := c.client.MGet(c.ctx, keys...).Result()
 	if err != nil {
-		return // Don't cache if serialization fails
+		return nil, err
 	}
+	values := make(map[string][]byte, len(keys))
+	for i, v := range vals {
+		if s, ok := v.(string); ok {
+			values[keys[i]] = []byte(s)
+		}
+	}
+	return values, nil
+}
 
+// setMulti implements blobStore by pipelining one SET per blob, in order.
+func (c *RedisCache) setMulti(blobs []blob, expiresAt time.Time) error {
 	// Calculate the cache duration from the entry's expiry time.
-	ttl := time.Until(entry.ExpiresAt)
+	ttl := time.Until(expiresAt)
 	if ttl <= 0 {
-		return // Already expired, don't cache.
+		return nil
 	}
-
-	// Set the value in Redis with the calculated TTL.
-	c.client.Set(c.ctx, key, data, ttl)
+	_, err := c.client.Pipelined(c.ctx, func(p redis.Pipeliner) error {
+		for _, b := range blobs {
+			p.Set(c.ctx, b.key, b.value, ttl)
+		}
+		return nil
+	})
+	return err
 }
 
-// Delete removes an entry from Redis.
-func (c *RedisCache) Delete(key string) {
-	c.client.Del(c.ctx, key)
-}
\ No newline at end of file
+// deleteMulti implements blobStore with a single DEL.
+func (c *RedisCache) deleteMulti(keys []string) error {
+	return c.client.Del(c.ctx, keys...).Err()
+}
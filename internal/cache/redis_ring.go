@@ -0,0 +1,222 @@
+// File: internal/cache/redis_ring.go
+package cache
+
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	"go-caching-proxy/internal/hashring"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RingNode describes one standalone Redis instance that takes part in a
+// RedisRingCache.
+type RingNode struct {
+	// Name identifies the node on the hash ring. Keeping the name stable while
+	// changing the address moves the node without reshuffling its keys.
+	// Defaults to Address when empty.
+	Name     string
+	Address  string
+	Password string
+	DB       int
+	Weight   int
+}
+
+func (n RingNode) id() string {
+	if n.Name != "" {
+		return n.Name
+	}
+	return n.Address
+}
+
+// ringMember is a node together with the cache that talks to it. inUse
+// counts the calls that resolved this member and haven't returned, so that a
+// removed node's connections are only closed once nothing uses them.
+type ringMember struct {
+	node  RingNode
+	cache *RedisCache
+	inUse sync.WaitGroup
+}
+
+// RedisRingCache spreads keys over several independent Redis instances using
+// a consistent-hash ring. Losing one node only loses the slice of the cache
+// that node owned: lookups for its keys become misses, while every other key
+// keeps hitting. It satisfies the Storer interface.
+type RedisRingCache struct {
+	replicas  int
+	chunkSize int
+
+	mu      sync.RWMutex
+	ring    *hashring.Ring
+	members map[string]*ringMember
+}
+
+// NewRedisRingCache creates a ring over the given nodes. replicas is the number
+// of virtual nodes per unit of weight (0 uses hashring.DefaultReplicas), and
+// chunkSize is passed on to every node's RedisCache.
+//
+// Unlike NewRedisCache, the nodes are not pinged: a node that is down at
+// startup simply reports misses until it comes back.
+func NewRedisRingCache(nodes []RingNode, replicas, chunkSize int) (*RedisRingCache, error) {
+	c := &RedisRingCache{
+		replicas:  replicas,
+		chunkSize: chunkSize,
+		members:   make(map[string]*ringMember),
+	}
+	if err := c.SetNodes(nodes); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetNodes rebalances the ring onto a new node list. Nodes whose settings are
+// unchanged keep their existing connections, new nodes are connected, and
+// removed nodes are closed once the calls still using them return. Because
+// the ring is consistent, only the keys owned by added or removed nodes
+// change owner; they are re-fetched from the origin on their next request.
+func (c *RedisRingCache) SetNodes(nodes []RingNode) error {
+	if len(nodes) == 0 {
+		return errors.New("redis ring: at least one node is required")
+	}
+
+	ring := hashring.New(c.replicas)
+	members := make(map[string]*ringMember, len(nodes))
+
+	c.mu.RLock()
+	for _, n := range nodes {
+		if n.Address == "" {
+			c.mu.RUnlock()
+			return errors.New("redis ring: node address is required")
+		}
+		id := n.id()
+		if _, dup := members[id]; dup {
+			c.mu.RUnlock()
+			return errors.New("redis ring: duplicate node " + id)
+		}
+
+		// Reuse the existing connection pool when the node is unchanged.
+		if old, ok := c.members[id]; ok && sameConnection(old.node, n) {
+			members[id] = &ringMember{node: n, cache: old.cache}
+		} else {
+			members[id] = &ringMember{node: n, cache: newRedisCacheFromClient(redis.NewClient(&redis.Options{
+				Addr:     n.Address,
+				Password: n.Password,
+				DB:       n.DB,
+			}), c.chunkSize)}
+		}
+		ring.Add(id, n.Weight)
+	}
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	old := c.members
+	c.ring = ring
+	c.members = members
+	c.mu.Unlock()
+
+	// Close connections that are no longer part of the ring. Calls only
+	// resolve members under the read lock, so none can start using an old
+	// member after the swap above.
+	for id, m := range old {
+		if cur, ok := members[id]; !ok || cur.cache != m.cache {
+			go func() {
+				m.inUse.Wait()
+				m.cache.Close()
+			}()
+		}
+	}
+	return nil
+}
+
+// Nodes returns the names of the nodes currently on the ring.
+func (c *RedisRingCache) Nodes() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.Nodes()
+}
+
+// Get retrieves an entry from the node that owns the key. Chunks of a large
+// entry always live on the same node as the entry itself, since the node is
+// chosen by the entry's key.
+func (c *RedisRingCache) Get(key string) (*CacheEntry, bool) {
+	m := c.nodeFor(key)
+	defer m.inUse.Done()
+	return m.cache.Get(key)
+}
+
+// Set stores an entry on the node that owns the key.
+func (c *RedisRingCache) Set(key string, entry CacheEntry) {
+	m := c.nodeFor(key)
+	defer m.inUse.Done()
+	m.cache.Set(key, entry)
+}
+
+// Delete removes an entry from the node that owns the key.
+func (c *RedisRingCache) Delete(key string) {
+	m := c.nodeFor(key)
+	defer m.inUse.Done()
+	m.cache.Delete(key)
+}
+
+// PurgeTag removes tagged entries from every node. Each node keeps the tag
+// sets for the entries it owns, so all of them have to be asked.
+func (c *RedisRingCache) PurgeTag(tag string) int {
+	n := 0
+	for _, m := range c.all() {
+		n += m.cache.PurgeTag(tag)
+		m.inUse.Done()
+	}
+	return n
+}
+
+// Tags returns the matching tags from every node.
+func (c *RedisRingCache) Tags(prefix string) []string {
+	var tags []string
+	for _, m := range c.all() {
+		tags = append(tags, m.cache.Tags(prefix)...)
+		m.inUse.Done()
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// Keys returns the matching keys from every node.
+func (c *RedisRingCache) Keys(prefix string) []string {
+	var keys []string
+	for _, m := range c.all() {
+		keys = append(keys, m.cache.Keys(prefix)...)
+		m.inUse.Done()
+	}
+	return keys
+}
+
+// all returns every current member, each marked in use; the caller must
+// call inUse.Done on each when finished with it.
+func (c *RedisRingCache) all() []*ringMember {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	members := make([]*ringMember, 0, len(c.members))
+	for _, m := range c.members {
+		m.inUse.Add(1)
+		members = append(members, m)
+	}
+	return members
+}
+
+// nodeFor looks up the member responsible for a key and marks it in use; the
+// caller must call inUse.Done when finished with it.
+func (c *RedisRingCache) nodeFor(key string) *ringMember {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	m := c.members[c.ring.Get(key)]
+	m.inUse.Add(1)
+	return m
+}
+
+// sameConnection reports whether two node configs can share a connection pool.
+func sameConnection(a, b RingNode) bool {
+	return a.Address == b.Address && a.Password == b.Password && a.DB == b.DB
+}
@@ -0,0 +1,90 @@
+// File: internal/cache/snapshot.go
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// snapshotRecord is one line of a snapshot file.
+type snapshotRecord struct {
+	Key   string     `json:"key"`
+	Entry CacheEntry `json:"entry"`
+}
+
+// Snapshot writes the cache contents to w as JSON lines, ordered from least to
+// most recently used so that Restore recreates the same recency order.
+func (c *LRUCache) Snapshot(w io.Writer) (int, error) {
+	// Copy the entries under the lock and do the (slow) encoding without it,
+	// so a snapshot never stalls live traffic.
+	c.mu.Lock()
+	records := make([]snapshotRecord, 0, c.ll.Len())
+	now := time.Now()
+	for elem := c.ll.Back(); elem != nil; elem = elem.Prev() {
+		e := elem.Value.(*lruEntry)
+		if now.After(e.value.ExpiresAt) {
+			continue
+		}
+		records = append(records, snapshotRecord{Key: e.key, Entry: e.value})
+	}
+	c.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	for i := range records {
+		if err := enc.Encode(&records[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(records), nil
+}
+
+// Restore loads a snapshot written by Snapshot. Entries that expired while the
+// proxy was down are skipped, and ExpiresAt is preserved for the rest.
+func (c *LRUCache) Restore(r io.Reader) (int, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	now := time.Now()
+	n := 0
+	for {
+		var rec snapshotRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		if now.After(rec.Entry.ExpiresAt) {
+			continue
+		}
+		// Records are ordered oldest first, so each Set pushes the entry in
+		// front of the previous one, rebuilding the original recency order.
+		c.Set(rec.Key, rec.Entry)
+		n++
+	}
+}
+
+// SaveSnapshot atomically writes a snapshot of s to path.
+func SaveSnapshot(path string, s Snapshotter) (int, error) {
+	var n int
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		var err error
+		n, err = s.Snapshot(w)
+		return err
+	})
+	return n, err
+}
+
+// LoadSnapshot restores s from the snapshot at path. A missing file is not an
+// error; it just means there is nothing to warm the cache with.
+func LoadSnapshot(path string, s Snapshotter) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return s.Restore(f)
+}
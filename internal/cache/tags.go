@@ -0,0 +1,52 @@
+// File: internal/cache/tags.go
+package cache
+
+import "strings"
+
+// tagIndex maps each tag to the set of keys whose entries carry it. It is the
+// in-process index used by LRUCache and DiskCache; callers provide locking.
+type tagIndex map[string]map[string]struct{}
+
+// add records that the entry stored under key carries the given tags.
+func (ix tagIndex) add(key string, tags []string) {
+	for _, tag := range tags {
+		keys, ok := ix[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			ix[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// remove forgets the tags of an entry that is being removed or replaced.
+func (ix tagIndex) remove(key string, tags []string) {
+	for _, tag := range tags {
+		if keys, ok := ix[tag]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(ix, tag)
+			}
+		}
+	}
+}
+
+// withPrefix returns every indexed tag starting with prefix.
+func (ix tagIndex) withPrefix(prefix string) []string {
+	var tags []string
+	for tag := range ix {
+		if strings.HasPrefix(tag, prefix) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// keys returns the keys carrying a tag.
+func (ix tagIndex) keys(tag string) []string {
+	keys := make([]string, 0, len(ix[tag]))
+	for k := range ix[tag] {
+		keys = append(keys, k)
+	}
+	return keys
+}
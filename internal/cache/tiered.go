@@ -0,0 +1,122 @@
+// File: internal/cache/tiered.go
+package cache
+
+import (
+	"errors"
+	"io"
+	"slices"
+)
+
+// ErrSnapshotUnsupported is returned when snapshotting a TieredCache whose
+// front tier can't be snapshotted.
+var ErrSnapshotUnsupported = errors.New("cache tier does not support snapshots")
+
+// TieredCache composes two caches: a small, fast front (usually LRUCache) and
+// a larger, slower back (usually DiskCache). Hits in the back are promoted to
+// the front so hot objects are served from memory.
+// It fulfills the Storer interface.
+type TieredCache struct {
+	front Storer
+	back  Storer
+}
+
+// NewTieredCache creates a cache that consults front before back.
+func NewTieredCache(front, back Storer) *TieredCache {
+	return &TieredCache{front: front, back: back}
+}
+
+// Get looks in the front cache first and falls back to the back cache.
+func (c *TieredCache) Get(key string) (*CacheEntry, bool) {
+	if entry, ok := c.front.Get(key); ok {
+		return entry, true
+	}
+	entry, ok := c.back.Get(key)
+	if !ok {
+		return nil, false
+	}
+	c.front.Set(key, *entry)
+	return entry, true
+}
+
+// Set writes the entry to both tiers.
+func (c *TieredCache) Set(key string, entry CacheEntry) {
+	c.front.Set(key, entry)
+	c.back.Set(key, entry)
+}
+
+// Delete removes the entry from both tiers.
+func (c *TieredCache) Delete(key string) {
+	c.front.Delete(key)
+	c.back.Delete(key)
+}
+
+// PurgeTag removes tagged entries from every tier that supports tags. The
+// count reported is the larger of the two, since most entries live in both.
+func (c *TieredCache) PurgeTag(tag string) int {
+	n := 0
+	for _, tier := range []Storer{c.front, c.back} {
+		if t, ok := tier.(Tagger); ok {
+			n = max(n, t.PurgeTag(tag))
+		}
+	}
+	return n
+}
+
+// Tags returns the tags in use in either tier.
+func (c *TieredCache) Tags(prefix string) []string {
+	var tags []string
+	for _, tier := range []Storer{c.front, c.back} {
+		if t, ok := tier.(Tagger); ok {
+			tags = append(tags, t.Tags(prefix)...)
+		}
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// Keys returns the keys in either tier.
+func (c *TieredCache) Keys(prefix string) []string {
+	var keys []string
+	for _, tier := range []Storer{c.front, c.back} {
+		if l, ok := tier.(Lister); ok {
+			keys = append(keys, l.Keys(prefix)...)
+		}
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+// Hits adds up the hits of both tiers: a request is counted by the front if
+// it hit there, and by the back otherwise.
+func (c *TieredCache) Hits(key string) (int64, bool) {
+	var total int64
+	found := false
+	for _, tier := range []Storer{c.front, c.back} {
+		if hc, ok := tier.(HitCounter); ok {
+			if n, ok := hc.Hits(key); ok {
+				total += n
+				found = true
+			}
+		}
+	}
+	return total, found
+}
+
+// Snapshot saves the front tier, which is what a restart loses; the back
+// tier is expected to persist by itself.
+func (c *TieredCache) Snapshot(w io.Writer) (int, error) {
+	s, ok := c.front.(Snapshotter)
+	if !ok {
+		return 0, ErrSnapshotUnsupported
+	}
+	return s.Snapshot(w)
+}
+
+// Restore warms the front tier from a snapshot.
+func (c *TieredCache) Restore(r io.Reader) (int, error) {
+	s, ok := c.front.(Snapshotter)
+	if !ok {
+		return 0, ErrSnapshotUnsupported
+	}
+	return s.Restore(r)
+}
@@ -12,21 +12,410 @@ type Config struct {
 	Server struct {
 		Port string `yaml:"port"`
 	} `yaml:"server"`
+	// Proxy is the default upstream, for requests no entry of Upstreams
+	// claims. It takes the settings of a named upstream except name, hosts,
+	// path_prefix and strip_prefix, and may be left without a target or
+	// backends if Upstreams are set.
 	Proxy struct {
-		Target string `yaml:"target"`
+		Upstream `yaml:",inline"`
 	} `yaml:"proxy"`
-	Cache struct {
+	// Upstreams are named origins, chosen per request by Host header or
+	// path prefix, in order.
+	Upstreams []Upstream `yaml:"upstreams"`
+	Cache     struct {
 		CacheType         string `yaml:"cache_type"`
 		DefaultTTLSeconds int    `yaml:"default_ttl_seconds"`
-		LRU               struct {
+		// MaxObjectBytes is the largest response body that is cached; 0
+		// means no limit.
+		MaxObjectBytes int64 `yaml:"max_object_bytes"`
+		// StaleSeconds keeps entries this long after they expire, to be
+		// served while an upstream's circuit breaker is open or instead of
+		// an error.
+		StaleSeconds int `yaml:"stale_seconds"`
+		// ChunkBytes is the largest body Redis stores as a single value;
+		// larger bodies are split into chunks of this size.
+		ChunkBytes int `yaml:"chunk_bytes"`
+		LRU        struct {
 			Size int `yaml:"size"`
 		} `yaml:"lru"`
+		Disk struct {
+			Path     string `yaml:"path"`
+			MaxBytes int64  `yaml:"max_bytes"`
+			// MemoryFront keeps recently used entries in an LRU cache of
+			// lru.size items in front of the disk.
+			MemoryFront bool `yaml:"memory_front"`
+		} `yaml:"disk"`
+		// Negative caches error responses and transport failures briefly.
+		Negative struct {
+			// TTLSeconds maps an exact status ("404") or a status class
+			// ("5xx") to a TTL. Statuses not listed are not cached.
+			TTLSeconds map[string]int `yaml:"ttl_seconds"`
+			// TransportErrorSeconds is the TTL of the 502/504 answered when
+			// the origin can't be reached or times out.
+			TransportErrorSeconds int `yaml:"transport_error_seconds"`
+		} `yaml:"negative"`
+		// Key controls how cache keys are built when no route overrides it.
+		Key KeyConfig `yaml:"key"`
+		// Namespace and Generation prefix every key sent to the backend.
+		// Bumping Generation (and reloading) invalidates the whole cache.
+		Namespace  string `yaml:"namespace"`
+		Generation int64  `yaml:"generation"`
+		// KeyHash hashes keys before storing them: "", "sha256" or "xxhash".
+		KeyHash  string `yaml:"key_hash"`
+		Snapshot struct {
+			// Path is where the in-memory cache is saved on shutdown and
+			// restored from on startup. Empty disables snapshots.
+			Path string `yaml:"path"`
+		} `yaml:"snapshot"`
 	} `yaml:"cache"`
 	Redis struct {
 		Address  string `yaml:"address"`
 		Password string `yaml:"password"`
 		DB       int    `yaml:"db"`
 	} `yaml:"redis"`
+	// Admin configures the JSON admin API on the admin port. The API is only
+	// served when a token is set.
+	Admin struct {
+		Token string `yaml:"token"`
+	} `yaml:"admin"`
+	// Purge controls who may send PURGE and BAN requests to the proxy port.
+	Purge struct {
+		AllowedCIDRs []string `yaml:"allowed_cidrs"`
+		Token        string   `yaml:"token"`
+	} `yaml:"purge"`
+	// Routes are evaluated in order; the first match supplies the cache
+	// rules for a request.
+	Routes    []Route `yaml:"routes"`
+	Memcached struct {
+		Servers []string `yaml:"servers"`
+		// MaxItemBytes is the servers' item size limit (memcached -I).
+		// Larger entries are split into chunks.
+		MaxItemBytes int `yaml:"max_item_bytes"`
+		TimeoutMS    int `yaml:"timeout_ms"`
+	} `yaml:"memcached"`
+	RedisRing struct {
+		// VirtualNodes is the number of ring positions per unit of node weight.
+		VirtualNodes int             `yaml:"virtual_nodes"`
+		Nodes        []RedisRingNode `yaml:"nodes"`
+	} `yaml:"redis_ring"`
+}
+
+// KeyConfig controls how cache keys are built. Inside a route, Template and
+// KeepParams replace the global values, IgnoreParams are added to the global
+// list, and the boolean switches can only be turned on.
+type KeyConfig struct {
+	// Template composes the key from parts of the request, e.g.
+	// "{method}|{host}|{url}|{header:X-Api-Version}". See key.Template.
+	Template string `yaml:"template"`
+
+	// SortQuery makes ?a=1&b=2 and ?b=2&a=1 share a key.
+	SortQuery bool `yaml:"sort_query"`
+	// IgnoreParams are query parameters left out of the key. Globs such as
+	// "utm_*" are allowed.
+	IgnoreParams []string `yaml:"ignore_params"`
+	// KeepParams, if set, is the only query parameters kept in the key.
+	KeepParams      []string `yaml:"keep_params"`
+	LowercaseHost   bool     `yaml:"lowercase_host"`
+	CollapseSlashes bool     `yaml:"collapse_slashes"`
+}
+
+// Route is one entry of the route table. A route matches when all of the
+// criteria it sets match; the first matching route supplies the rules.
+type Route struct {
+	Name string `yaml:"name"`
+
+	// Host matches the Host header exactly, or any subdomain for a pattern
+	// like "*.example.com".
+	Host string `yaml:"host"`
+	// The path can be matched by prefix, by glob ("/repos/*/*/releases/**")
+	// and by regular expression.
+	PathPrefix string `yaml:"path_prefix"`
+	PathGlob   string `yaml:"path_glob"`
+	PathRegex  string `yaml:"path_regex"`
+	// Methods restricts the route to these methods; empty matches any.
+	Methods []string `yaml:"methods"`
+
+	// TTLSeconds overrides cache.default_ttl_seconds for this route.
+	TTLSeconds int `yaml:"ttl_seconds"`
+	// ForceCache caches responses even if the origin marks them no-store or
+	// private, or sets cookies (which are dropped from the stored copy).
+	ForceCache bool `yaml:"force_cache"`
+	// Bypass sends requests straight to the origin, skipping the cache.
+	Bypass bool `yaml:"bypass"`
+
+	Key  KeyConfig  `yaml:"key"`
+	Post PostConfig `yaml:"post"`
+}
+
+// PostConfig opts a route into caching POST requests, for APIs such as
+// GraphQL and search that use POST for idempotent reads. The request body is
+// hashed into the cache key.
+type PostConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxBodyBytes is the largest body that is hashed; bigger requests are
+	// passed through uncached. Defaults to 64KB.
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// CanonicalJSON ignores whitespace and object key order in JSON bodies.
+	CanonicalJSON bool `yaml:"canonical_json"`
+}
+
+// Upstream is one named origin.
+type Upstream struct {
+	Name string `yaml:"name"`
+	// Target is shorthand for a single backend; use Backends for several.
+	Target   string    `yaml:"target"`
+	Backends []Backend `yaml:"backends"`
+	// Balance picks among the backends: "round_robin" (the default),
+	// "least_connections", "weighted" or "consistent_hash", which sends
+	// each cache key to the same backend to keep that origin's cache warm.
+	Balance string `yaml:"balance"`
+	// Hosts routes requests whose Host header matches (exact or
+	// "*.example.com") to this upstream.
+	Hosts []string `yaml:"hosts"`
+	// PathPrefix routes requests under it to this upstream; StripPrefix
+	// removes it before forwarding.
+	PathPrefix  string `yaml:"path_prefix"`
+	StripPrefix bool   `yaml:"strip_prefix"`
+	// PreserveHost sends the client's Host header to the origin instead
+	// of the target's host name.
+	PreserveHost bool `yaml:"preserve_host"`
+	// Namespace replaces cache.namespace for this upstream's entries.
+	Namespace      string         `yaml:"namespace"`
+	Transport      Transport      `yaml:"transport"`
+	HealthCheck    HealthCheck    `yaml:"health_check"`
+	Retry          Retry          `yaml:"retry"`
+	Hedge          Hedge          `yaml:"hedge"`
+	CircuitBreaker CircuitBreaker `yaml:"circuit_breaker"`
+	Credentials    Credentials    `yaml:"credentials"`
+	Quota          Quota          `yaml:"quota"`
+}
+
+// Quota protects an upstream's API rate limit, as reported by the origin in
+// its response headers, so that the proxy doesn't use it up for everyone.
+// It is off unless MinRemaining is set.
+type Quota struct {
+	// While fewer than MinRemaining requests are left before the limit
+	// resets, or after a 429 until its Retry-After, requests are served from
+	// stale entries (see cache.stale_seconds) when possible, and misses are
+	// handled according to OnMiss.
+	MinRemaining int `yaml:"min_remaining"`
+	// RemainingHeader and ResetHeader name the headers with the requests
+	// left and when the limit resets, in Unix seconds or in seconds from
+	// now. Default X-RateLimit-Remaining and X-RateLimit-Reset.
+	RemainingHeader string `yaml:"remaining_header"`
+	ResetHeader     string `yaml:"reset_header"`
+	// OnMiss is "reject" (the default), answering 429 with a Retry-After,
+	// or "queue", spreading the remaining requests evenly until the reset
+	// and making each wait for its turn, up to QueueTimeoutMS (default
+	// 5000), before rejecting it.
+	OnMiss         string `yaml:"on_miss"`
+	QueueTimeoutMS int    `yaml:"queue_timeout_ms"`
+}
+
+// Credentials are added to every request sent to an upstream, so that
+// clients don't need the API tokens themselves. They are added after the
+// cache key is built and removed from the origin's response headers, so
+// they end up in neither the cache nor the responses.
+type Credentials struct {
+	// Headers are set on every request, e.g. an API version or an API key.
+	Headers map[string]Secret `yaml:"headers"`
+	// BearerToken is sent as "Authorization: Bearer <token>".
+	BearerToken Secret `yaml:"bearer_token"`
+	// Query parameters are set on every request, for APIs that take their
+	// key in the URL.
+	Query map[string]Secret `yaml:"query"`
+	// OAuth2 obtains bearer tokens with the client credentials grant.
+	OAuth2 OAuth2 `yaml:"oauth2"`
+	// StripHeaders are removed from client requests before the credentials
+	// are added. Defaults to Authorization and Proxy-Authorization whenever
+	// any credential is configured.
+	StripHeaders []string `yaml:"strip_headers"`
+	// InKey adds a hash of the credentials to the cache key, for origins
+	// whose answers depend on the account asking, so that rotating to
+	// another account's token doesn't serve the old account's entries.
+	InKey bool `yaml:"in_key"`
+}
+
+// OAuth2 configures the OAuth2 client credentials grant. It is off unless
+// TokenURL is set.
+type OAuth2 struct {
+	TokenURL     string   `yaml:"token_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret Secret   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+	// Params are extra form parameters of the token request, e.g.
+	// "audience".
+	Params map[string]string `yaml:"params"`
+	// ClientAuth is how the client authenticates: "basic" (HTTP Basic, the
+	// default) or "body" (client_id and client_secret form parameters).
+	ClientAuth string `yaml:"client_auth"`
+	// Tokens are refreshed RefreshBeforeSeconds (default 60) before they
+	// expire, but never earlier than halfway through their lifetime.
+	RefreshBeforeSeconds int `yaml:"refresh_before_seconds"`
+	// TimeoutMS limits each token request. Defaults to 10000.
+	TimeoutMS int `yaml:"timeout_ms"`
+}
+
+// Secret is a value given inline, or read from a file or an environment
+// variable. A plain string in YAML is an inline value. Files are re-read
+// when they change, so rotated tokens are picked up without a restart.
+type Secret struct {
+	Value string `yaml:"value"`
+	File  string `yaml:"file"`
+	Env   string `yaml:"env"`
+}
+
+// UnmarshalYAML accepts a plain string as an inline value.
+func (s *Secret) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = Secret{Value: node.Value}
+		return nil
+	}
+	type plain Secret
+	return node.Decode((*plain)(s))
+}
+
+// IsZero reports whether no source is set.
+func (s Secret) IsZero() bool {
+	return s == Secret{}
+}
+
+// CircuitBreaker configures when an upstream stops receiving traffic. It
+// is off unless ErrorRate or SlowRate (with SlowMS) is set.
+type CircuitBreaker struct {
+	// The breaker opens when at least MinRequests (default 20) were sent
+	// over the last WindowSeconds (default 10), and at least ErrorRate of
+	// them failed or SlowRate of them took SlowMS or longer.
+	WindowSeconds int     `yaml:"window_seconds"`
+	MinRequests   int     `yaml:"min_requests"`
+	ErrorRate     float64 `yaml:"error_rate"`
+	SlowRate      float64 `yaml:"slow_rate"`
+	SlowMS        int     `yaml:"slow_ms"`
+	// After OpenSeconds (default 30) it lets HalfOpenRequests (default 3)
+	// probes through, and closes if they all succeed.
+	OpenSeconds      int `yaml:"open_seconds"`
+	HalfOpenRequests int `yaml:"half_open_requests"`
+	// Fallback is answered while open when no stale entry is cached.
+	Fallback Fallback `yaml:"fallback"`
+}
+
+// Fallback is a canned response.
+type Fallback struct {
+	Status  int               `yaml:"status"` // Defaults to 503
+	Body    string            `yaml:"body"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Retry configures retries of idempotent requests that failed with a
+// connection error or one of the OnStatus statuses.
+type Retry struct {
+	// MaxRetries is the per-request retry budget. 0 disables retries.
+	MaxRetries int `yaml:"max_retries"`
+	// OnStatus lists the statuses worth retrying. Defaults to 502, 503, 504.
+	OnStatus []int `yaml:"on_status"`
+	// Retry n waits a random time up to BackoffMS * 2^(n-1), capped at
+	// MaxBackoffMS. Default 25ms and 1s.
+	BackoffMS    int `yaml:"backoff_ms"`
+	MaxBackoffMS int `yaml:"max_backoff_ms"`
+	// BudgetRatio caps the retries of the whole upstream at this fraction
+	// of its requests over the last 10 seconds (default 0.2), plus
+	// BudgetMinRetries (default 10) so that quiet upstreams can retry too.
+	BudgetRatio      float64 `yaml:"budget_ratio"`
+	BudgetMinRetries int     `yaml:"budget_min_retries"`
+}
+
+// Hedge configures hedged requests: when an idempotent request without a
+// body has waited longer than the Percentile of recent response times, a
+// second one is sent and whichever answers first is used.
+type Hedge struct {
+	// Percentile is e.g. 95. 0 disables hedging.
+	Percentile float64 `yaml:"percentile"`
+	// MinDelayMS is the least time to wait before hedging.
+	MinDelayMS int `yaml:"min_delay_ms"`
+}
+
+// HealthCheck configures how an upstream's backends are taken out of
+// rotation and put back.
+type HealthCheck struct {
+	// Active probes each backend with a GET of Path. They are off when Path
+	// is empty.
+	Active struct {
+		Path       string `yaml:"path"`
+		IntervalMS int    `yaml:"interval_ms"` // Defaults to 10000
+		TimeoutMS  int    `yaml:"timeout_ms"`  // Defaults to 2000
+		// ExpectedStatus lists the statuses counted as healthy. Empty means
+		// any 2xx or 3xx.
+		ExpectedStatus []int `yaml:"expected_status"`
+		// A backend goes out of rotation after UnhealthyThreshold failed
+		// probes in a row (default 3) and back in after HealthyThreshold
+		// good ones (default 2).
+		HealthyThreshold   int `yaml:"healthy_threshold"`
+		UnhealthyThreshold int `yaml:"unhealthy_threshold"`
+	} `yaml:"active"`
+	// Passive ejects a backend for EjectSeconds (default 30) after
+	// ConsecutiveFailures live requests in a row failed with a 5xx or a
+	// connection error. It is off when ConsecutiveFailures is 0.
+	Passive struct {
+		ConsecutiveFailures int `yaml:"consecutive_failures"`
+		EjectSeconds        int `yaml:"eject_seconds"`
+	} `yaml:"passive"`
+}
+
+// Backend is one origin server of an upstream.
+type Backend struct {
+	URL string `yaml:"url"`
+	// Weight scales the share of requests the "weighted" and
+	// "consistent_hash" strategies send here. Defaults to 1.
+	Weight int `yaml:"weight"`
+}
+
+// Transport configures the connections to an upstream. Zero values keep
+// the defaults of Go's http.DefaultTransport.
+type Transport struct {
+	// DialTimeoutMS limits connecting, TLSHandshakeTimeoutMS the TLS
+	// handshake after it, and ResponseHeaderTimeoutMS the wait for the
+	// origin's response headers after the request is sent.
+	DialTimeoutMS           int `yaml:"dial_timeout_ms"`
+	TLSHandshakeTimeoutMS   int `yaml:"tls_handshake_timeout_ms"`
+	ResponseHeaderTimeoutMS int `yaml:"response_header_timeout_ms"`
+	// IdleConnTimeoutMS closes pooled connections idle for this long.
+	IdleConnTimeoutMS int `yaml:"idle_conn_timeout_ms"`
+
+	// MaxIdleConns and MaxIdleConnsPerHost size the pool of idle
+	// connections; MaxConnsPerHost caps all connections to one backend.
+	MaxIdleConns        int `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int `yaml:"max_conns_per_host"`
+
+	// DisableHTTP2 keeps connections on HTTP/1.1.
+	DisableHTTP2 bool `yaml:"disable_http2"`
+
+	TLS TLS `yaml:"tls"`
+}
+
+// TLS configures how an upstream's certificates are verified and which
+// client certificate is presented.
+type TLS struct {
+	// CAFile is a PEM bundle of the CAs trusted instead of the system's.
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are the client certificate and key for mTLS.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ServerName overrides the name sent in SNI and verified against the
+	// origin's certificate, e.g. when backends are addressed by IP.
+	ServerName string `yaml:"server_name"`
+	// InsecureSkipVerify disables certificate verification. Development
+	// only: it makes the connection open to interception.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+// RedisRingNode is one standalone Redis instance in the "redis_ring" cache.
+type RedisRingNode struct {
+	Name     string `yaml:"name"`
+	Address  string `yaml:"address"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	Weight   int    `yaml:"weight"`
 }
 
 func (c *Config) GetDefaultTTL() time.Duration {
@@ -44,4 +433,4 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 	return &cfg, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,118 @@
+// File: internal/hashring/hashring.go
+package hashring
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is the number of virtual nodes placed on the ring for a node
+// with weight 1. More virtual nodes give a smoother key distribution at the
+// cost of a slightly larger ring.
+const DefaultReplicas = 160
+
+// Ring is a consistent-hash ring with virtual nodes. Each physical node is
+// hashed onto the ring many times so that keys spread evenly, and removing a
+// node only moves the keys that node owned.
+//
+// A Ring is not safe for concurrent mutation. Callers build a ring once and
+// swap it atomically when the node list changes.
+type Ring struct {
+	replicas int
+	hashes   []uint32          // Sorted virtual node positions
+	owners   map[uint32]string // Virtual node position -> physical node name
+	nodes    map[string]int    // Physical node name -> weight
+}
+
+// New creates an empty ring. A non-positive replica count uses DefaultReplicas.
+func New(replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	return &Ring{
+		replicas: replicas,
+		owners:   make(map[uint32]string),
+		nodes:    make(map[string]int),
+	}
+}
+
+// Add places a node on the ring. The weight multiplies the number of virtual
+// nodes, so a node with weight 2 receives roughly twice as many keys.
+func (r *Ring) Add(node string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	if _, ok := r.nodes[node]; ok {
+		return
+	}
+	r.nodes[node] = weight
+
+	for i := 0; i < r.replicas*weight; i++ {
+		h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "#" + node))
+		// On the (rare) collision, the first node to claim a position keeps it.
+		if _, taken := r.owners[h]; taken {
+			continue
+		}
+		r.owners[h] = node
+		r.hashes = append(r.hashes, h)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// Get returns the node that owns the given key, or "" if the ring is empty.
+func (r *Ring) Get(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+
+	// Find the first virtual node clockwise from the key's position, wrapping
+	// around to the start of the ring if necessary.
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// GetFunc returns the first node clockwise from the key's position that
+// accept approves, or "" if it approves none. Skipping a node this way moves
+// only the keys that node owned, to the nodes that follow it on the ring.
+func (r *Ring) GetFunc(key string, accept func(node string) bool) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	tried := make(map[string]bool, len(r.nodes))
+	for i := range r.hashes {
+		node := r.owners[r.hashes[(start+i)%len(r.hashes)]]
+		if tried[node] {
+			continue
+		}
+		if accept(node) {
+			return node
+		}
+		tried[node] = true
+		if len(tried) == len(r.nodes) {
+			break
+		}
+	}
+	return ""
+}
+
+// Nodes returns the names of all physical nodes on the ring.
+func (r *Ring) Nodes() []string {
+	names := make([]string, 0, len(r.nodes))
+	for name := range r.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Len returns the number of physical nodes on the ring.
+func (r *Ring) Len() int {
+	return len(r.nodes)
+}
@@ -0,0 +1,45 @@
+// File: internal/key/body.go
+package key
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// HashBody returns a hex SHA-256 digest of a request body, for use in the
+// cache keys of POST requests that are really reads (GraphQL, search APIs).
+//
+// With canonicalJSON set, a valid JSON body is re-encoded first, so
+// differences in whitespace and object key order don't produce different
+// keys. Bodies that aren't valid JSON are hashed as-is.
+func HashBody(body []byte, canonicalJSON bool) string {
+	if canonicalJSON {
+		if canon, ok := canonicalizeJSON(body); ok {
+			body = canon
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalizeJSON decodes and re-encodes a JSON document. encoding/json
+// writes object keys in sorted order and without insignificant whitespace,
+// and UseNumber keeps numbers exactly as they were written.
+func canonicalizeJSON(body []byte) ([]byte, bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+	if dec.More() {
+		return nil, false // Trailing data; not a single JSON document
+	}
+	canon, err := json.Marshal(v)
+	if err != nil {
+		return nil, false
+	}
+	return canon, true
+}
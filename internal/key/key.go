@@ -2,13 +2,16 @@
 package key
 
 import (
-	"fmt"
 	"net/http"
 )
 
+// defaultTemplate is used by Generate.
+var defaultTemplate = MustParseTemplate(DefaultTemplate)
+
 // Generate creates a unique cache key for an HTTP request.
 // A good key is essential for preventing cache collisions. We include the method,
 // host, and the full URL (path + query) to ensure uniqueness.
+// Use a Template to compose keys from other parts of the request.
 func Generate(r *http.Request) string {
-	return fmt.Sprintf("%s|%s|%s", r.Method, r.Host, r.URL.String())
-}
\ No newline at end of file
+	return defaultTemplate.Generate(r)
+}
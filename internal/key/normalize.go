@@ -0,0 +1,170 @@
+// File: internal/key/normalize.go
+package key
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Normalizer rewrites the parts of a request URL that don't change the
+// response, so that equivalent requests share one cache key. It only affects
+// the key; the request forwarded to the origin is left untouched.
+type Normalizer struct {
+	// SortQuery orders query parameters by name (and value), so that
+	// ?a=1&b=2 and ?b=2&a=1 produce the same key.
+	SortQuery bool
+
+	// IgnoreParams lists query parameters to drop, such as tracking
+	// parameters and cache-busters. Entries are glob patterns ("utm_*").
+	IgnoreParams []string
+
+	// KeepParams, when non-empty, drops every query parameter that doesn't
+	// match one of these glob patterns.
+	KeepParams []string
+
+	// LowercaseHost lowercases the Host header.
+	LowercaseHost bool
+
+	// CollapseSlashes replaces runs of slashes in the path with one slash.
+	CollapseSlashes bool
+}
+
+// enabled reports whether the normalizer changes anything at all.
+func (n *Normalizer) enabled() bool {
+	return n.SortQuery || n.LowercaseHost || n.CollapseSlashes ||
+		len(n.IgnoreParams) > 0 || len(n.KeepParams) > 0
+}
+
+// Apply returns a shallow copy of r with a normalized Host and URL. The
+// original request is not modified.
+func (n *Normalizer) Apply(r *http.Request) *http.Request {
+	if !n.enabled() {
+		return r
+	}
+
+	nr := r.WithContext(r.Context())
+	u := *r.URL
+	nr.URL = &u
+
+	if n.LowercaseHost {
+		nr.Host = strings.ToLower(nr.Host)
+		u.Host = strings.ToLower(u.Host)
+	}
+	if n.CollapseSlashes && strings.Contains(u.Path, "//") {
+		u.Path = collapseSlashes(u.Path)
+		u.RawPath = ""
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = n.normalizeQuery(u.RawQuery)
+	}
+	return nr
+}
+
+// normalizeQuery filters and optionally sorts a raw query string.
+func (n *Normalizer) normalizeQuery(raw string) string {
+	type param struct{ name, value string }
+	var params []param
+
+	for _, pair := range strings.Split(raw, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		// Compare on the decoded name so "utm%5Fsource" is still ignored, but
+		// keep the original encoding for the key itself.
+		decoded, err := url.QueryUnescape(name)
+		if err != nil {
+			decoded = name
+		}
+		if matchAny(n.IgnoreParams, decoded) {
+			continue
+		}
+		if len(n.KeepParams) > 0 && !matchAny(n.KeepParams, decoded) {
+			continue
+		}
+		params = append(params, param{name: name, value: value})
+	}
+
+	if n.SortQuery {
+		sort.SliceStable(params, func(i, j int) bool {
+			if params[i].name != params[j].name {
+				return params[i].name < params[j].name
+			}
+			return params[i].value < params[j].value
+		})
+	}
+
+	var b strings.Builder
+	for i, p := range params {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(p.name)
+		if p.value != "" {
+			b.WriteByte('=')
+			b.WriteString(p.value)
+		}
+	}
+	return b.String()
+}
+
+// matchAny reports whether name matches one of the glob patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// collapseSlashes replaces every run of slashes with a single slash.
+func collapseSlashes(p string) string {
+	var b strings.Builder
+	b.Grow(len(p))
+	prevSlash := false
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' {
+			if prevSlash {
+				continue
+			}
+			prevSlash = true
+		} else {
+			prevSlash = false
+		}
+		b.WriteByte(p[i])
+	}
+	return b.String()
+}
+
+// Builder produces cache keys by normalizing a request and then applying a
+// template to it.
+type Builder struct {
+	Template   *Template
+	Normalizer Normalizer
+}
+
+// NewBuilder creates a Builder. A nil template uses DefaultTemplate.
+func NewBuilder(t *Template, n Normalizer) *Builder {
+	if t == nil {
+		t = defaultTemplate
+	}
+	return &Builder{Template: t, Normalizer: n}
+}
+
+// Generate builds the cache key for a request.
+func (b *Builder) Generate(r *http.Request) string {
+	return b.Template.Generate(b.Normalizer.Apply(r))
+}
+
+// Primary returns the normalized host and URL of a request, e.g.
+// "example.com/items/42?page=2". Unlike Generate it ignores the method,
+// headers and everything else a template may add, so every variant of a URL
+// shares the same primary key.
+func (b *Builder) Primary(r *http.Request) string {
+	nr := b.Normalizer.Apply(r)
+	return nr.Host + nr.URL.RequestURI()
+}
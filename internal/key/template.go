@@ -0,0 +1,193 @@
+// File: internal/key/template.go
+package key
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultTemplate reproduces the original METHOD|Host|URL key.
+const DefaultTemplate = "{method}|{host}|{url}"
+
+// Template composes a cache key from parts of a request. It is written as
+// literal text with placeholders in braces, for example
+//
+//	{method}|{host}|{path}|{header:X-Api-Version}|{query:page}
+//
+// Supported placeholders:
+//
+//	{method}          request method
+//	{scheme}          "http" or "https"
+//	{host}            Host header
+//	{path}            URL path
+//	{query}           raw query string
+//	{url}             path and query, as in r.URL.String()
+//	{header:Name}     value(s) of a request header, e.g. {header:X-Tenant-ID}
+//	{cookie:name}     value of a cookie
+//	{query:name}      value(s) of one query parameter
+//	{segment:N}       Nth path segment, starting at 1
+//	{client_ip}       client address without the port
+//
+// In placeholder values, "|" and `\` are escaped with a backslash, so that a
+// value can't pass for a separator and make two requests share a key.
+type Template struct {
+	source string
+	parts  []part
+	// headers are the request headers the key depends on, in canonical
+	// form. A {cookie:name} placeholder keys a single cookie, not the whole
+	// Cookie header, so it doesn't count.
+	headers map[string]bool
+}
+
+// part is either a literal string or a placeholder that is resolved per request.
+type part struct {
+	literal string
+	resolve func(r *http.Request) string
+}
+
+// ParseTemplate compiles a template string.
+func ParseTemplate(s string) (*Template, error) {
+	t := &Template{source: s, headers: make(map[string]bool)}
+	rest := s
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.parts = append(t.parts, part{literal: rest})
+			break
+		}
+		if open > 0 {
+			t.parts = append(t.parts, part{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("key template %q: unclosed '{'", s)
+		}
+		spec := rest[open+1 : open+end]
+		resolve, err := placeholder(spec)
+		if err != nil {
+			return nil, fmt.Errorf("key template %q: %w", s, err)
+		}
+		if name, arg, _ := strings.Cut(spec, ":"); name == "header" {
+			t.headers[http.CanonicalHeaderKey(arg)] = true
+		}
+		t.parts = append(t.parts, part{resolve: resolve})
+		rest = rest[open+end+1:]
+	}
+	return t, nil
+}
+
+// MustParseTemplate is like ParseTemplate but panics on error. It is intended
+// for templates that are known to be valid at compile time.
+func MustParseTemplate(s string) *Template {
+	t, err := ParseTemplate(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// String returns the source text of the template.
+func (t *Template) String() string {
+	return t.source
+}
+
+// VariesOn reports whether keys built by the template include the given
+// request header, so that responses which Vary on it are stored separately
+// for each of its values.
+func (t *Template) VariesOn(header string) bool {
+	return t.headers[http.CanonicalHeaderKey(header)]
+}
+
+// Generate builds the cache key for a request.
+func (t *Template) Generate(r *http.Request) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.resolve != nil {
+			b.WriteString(escapeValue(p.resolve(r)))
+		} else {
+			b.WriteString(p.literal)
+		}
+	}
+	return b.String()
+}
+
+// valueEscaper escapes the separator, and the escape character itself.
+var valueEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`)
+
+// escapeValue escapes a placeholder's value for use in a key.
+func escapeValue(v string) string {
+	if !strings.ContainsAny(v, `|\`) {
+		return v
+	}
+	return valueEscaper.Replace(v)
+}
+
+// placeholder returns the resolver for one {name} or {name:arg} placeholder.
+func placeholder(spec string) (func(r *http.Request) string, error) {
+	name, arg, hasArg := strings.Cut(spec, ":")
+	if hasArg && arg == "" {
+		return nil, fmt.Errorf("placeholder {%s} needs an argument", spec)
+	}
+
+	switch name {
+	case "method":
+		return func(r *http.Request) string { return r.Method }, nil
+	case "scheme":
+		return func(r *http.Request) string {
+			if r.TLS != nil {
+				return "https"
+			}
+			return "http"
+		}, nil
+	case "host":
+		return func(r *http.Request) string { return r.Host }, nil
+	case "path":
+		return func(r *http.Request) string { return r.URL.Path }, nil
+	case "query":
+		if hasArg {
+			return func(r *http.Request) string { return strings.Join(r.URL.Query()[arg], ",") }, nil
+		}
+		return func(r *http.Request) string { return r.URL.RawQuery }, nil
+	case "url":
+		return func(r *http.Request) string { return r.URL.String() }, nil
+	case "header":
+		if !hasArg {
+			return nil, fmt.Errorf("placeholder {header} needs a header name")
+		}
+		return func(r *http.Request) string { return strings.Join(r.Header.Values(arg), ",") }, nil
+	case "cookie":
+		if !hasArg {
+			return nil, fmt.Errorf("placeholder {cookie} needs a cookie name")
+		}
+		return func(r *http.Request) string {
+			if c, err := r.Cookie(arg); err == nil {
+				return c.Value
+			}
+			return ""
+		}, nil
+	case "segment":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("placeholder {segment:%s} needs a positive index", arg)
+		}
+		return func(r *http.Request) string {
+			segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+			if n > len(segments) {
+				return ""
+			}
+			return segments[n-1]
+		}, nil
+	case "client_ip":
+		return func(r *http.Request) string {
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				return host
+			}
+			return r.RemoteAddr
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown placeholder {%s}", spec)
+	}
+}
@@ -10,8 +10,41 @@ import (
 type Metrics struct {
 	CacheHits   prometheus.Counter
 	CacheMisses prometheus.Counter
-	CacheSize   prometheus.Gauge
-	Latency     prometheus.Histogram
+	// NegativeHits counts requests answered from a cached error response.
+	NegativeHits prometheus.Counter
+	CacheSize    prometheus.Gauge
+	Latency      prometheus.Histogram
+	// BackendRequests counts requests forwarded, by upstream and backend.
+	BackendRequests *prometheus.CounterVec
+	// BackendHealthy is 1 for backends in rotation and 0 for the others.
+	BackendHealthy *prometheus.GaugeVec
+	// HealthChecks counts active probes, by result ("success" or "failure").
+	HealthChecks *prometheus.CounterVec
+	// BackendEjections counts backends ejected by passive outlier detection.
+	BackendEjections *prometheus.CounterVec
+	// Retries counts retried requests, by reason ("error" or "status").
+	Retries *prometheus.CounterVec
+	// RetriesDenied counts retries not made because the budget was spent.
+	RetriesDenied *prometheus.CounterVec
+	// Hedges counts hedged requests sent, and how many of them won.
+	Hedges *prometheus.CounterVec
+	// BreakerState is each upstream's circuit breaker state: 0 closed,
+	// 1 half-open, 2 open.
+	BreakerState *prometheus.GaugeVec
+	// BreakerTransitions counts breaker state changes, by new state.
+	BreakerTransitions *prometheus.CounterVec
+	// BreakerRejected counts requests not sent because a breaker was open.
+	BreakerRejected *prometheus.CounterVec
+	// StaleHits counts requests answered with an expired entry because the
+	// origin couldn't be asked.
+	StaleHits prometheus.Counter
+	// QuotaRemaining is the rate-limit quota an upstream last reported.
+	QuotaRemaining *prometheus.GaugeVec
+	// QuotaLow is 1 while an upstream's quota is being protected.
+	QuotaLow *prometheus.GaugeVec
+	// QuotaThrottled counts requests kept from an upstream with low quota,
+	// by action: "stale", "queued" or "rejected".
+	QuotaThrottled *prometheus.CounterVec
 }
 
 // New creates and registers the Prometheus metrics.
@@ -25,6 +58,10 @@ func New() *Metrics {
 			Name: "proxy_cache_misses_total",
 			Help: "The total number of cache misses",
 		}),
+		NegativeHits: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "proxy_cache_negative_hits_total",
+			Help: "The total number of requests answered from a negatively cached error",
+		}),
 		CacheSize: promauto.NewGauge(prometheus.GaugeOpts{
 			Name: "proxy_cache_size_items",
 			Help: "The current number of items in the cache",
@@ -34,5 +71,61 @@ func New() *Metrics {
 			Help:    "A histogram of the request latency.",
 			Buckets: prometheus.LinearBuckets(0.1, 0.1, 10), // 10 buckets, 0.1s width
 		}),
+		BackendRequests: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_backend_requests_total",
+			Help: "The total number of requests forwarded to each backend",
+		}, []string{"upstream", "backend"}),
+		BackendHealthy: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "proxy_backend_healthy",
+			Help: "Whether each backend is in rotation (1) or not (0)",
+		}, []string{"upstream", "backend"}),
+		HealthChecks: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_backend_health_checks_total",
+			Help: "The total number of active health probes, by result",
+		}, []string{"upstream", "backend", "result"}),
+		BackendEjections: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_backend_ejections_total",
+			Help: "The total number of times passive outlier detection ejected a backend",
+		}, []string{"upstream", "backend"}),
+		Retries: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_upstream_retries_total",
+			Help: "The total number of retried origin requests, by reason",
+		}, []string{"upstream", "reason"}),
+		RetriesDenied: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_upstream_retries_denied_total",
+			Help: "The total number of retries skipped because the upstream's retry budget was spent",
+		}, []string{"upstream"}),
+		Hedges: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_upstream_hedges_total",
+			Help: "The total number of hedged origin requests, by result (sent or won)",
+		}, []string{"upstream", "result"}),
+		BreakerState: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "proxy_circuit_breaker_state",
+			Help: "The state of each upstream's circuit breaker (0 closed, 1 half-open, 2 open)",
+		}, []string{"upstream"}),
+		BreakerTransitions: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_circuit_breaker_transitions_total",
+			Help: "The total number of circuit breaker state changes, by new state",
+		}, []string{"upstream", "state"}),
+		BreakerRejected: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_circuit_breaker_rejected_total",
+			Help: "The total number of requests not sent to an upstream because its circuit breaker was open",
+		}, []string{"upstream"}),
+		StaleHits: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "proxy_cache_stale_hits_total",
+			Help: "The total number of requests answered with an expired entry because the origin was unavailable",
+		}),
+		QuotaRemaining: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "proxy_upstream_quota_remaining",
+			Help: "The rate-limit quota an upstream last reported as remaining",
+		}, []string{"upstream"}),
+		QuotaLow: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "proxy_upstream_quota_low",
+			Help: "Whether an upstream's rate-limit quota is low and being protected (1) or not (0)",
+		}, []string{"upstream"}),
+		QuotaThrottled: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_upstream_quota_throttled_total",
+			Help: "The total number of requests served stale, queued or rejected to protect an upstream's quota",
+		}, []string{"upstream", "action"}),
 	}
 }
@@ -1,12 +1,24 @@
 // File: internal/middleware/auth.go
 package middleware
 
-import "net/http"
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
 
-// Auth is a placeholder for a middleware that could handle API key/JWT authentication.
-func Auth(next http.Handler) http.Handler {
+// Auth is a middleware that only lets through requests carrying the given
+// token as "Authorization: Bearer <token>". The comparison runs in constant
+// time so the token can't be guessed byte by byte. An empty token rejects
+// every request, so a missing setting never leaves an endpoint open.
+func Auth(token string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// TODO: Implement authentication logic here.
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,51 @@
+// File: internal/proxy/breaker.go
+package proxy
+
+import (
+	"go-caching-proxy/internal/upstream"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// watchBreaker logs and exports the state changes of an upstream's circuit
+// breaker.
+func (h *Handler) watchBreaker(up *upstream.Upstream) {
+	h.metrics.BreakerState.WithLabelValues(up.Name).Set(float64(upstream.Closed))
+	up.Breaker.OnStateChange(func(from, to upstream.BreakerState) {
+		log := h.logger.With("upstream", up.Name, "from", from.String(), "to", to.String())
+		if to == upstream.Open {
+			log.Warn("circuit breaker opened")
+		} else {
+			log.Info("circuit breaker state changed")
+		}
+		h.metrics.BreakerState.WithLabelValues(up.Name).Set(float64(to))
+		h.metrics.BreakerTransitions.WithLabelValues(up.Name, to.String()).Inc()
+	})
+}
+
+// serveCircuitOpen answers a request that wasn't sent because the
+// upstream's circuit breaker is open: with the expired entry for it if one
+// is kept, or else with the breaker's fallback response.
+func (h *Handler) serveCircuitOpen(w http.ResponseWriter, r *http.Request) {
+	up := upstreamFrom(r.Context())
+	h.metrics.BreakerRejected.WithLabelValues(up.Name).Inc()
+
+	if state, ok := r.Context().Value(requestStateContextKey).(*requestState); ok && state.stale != nil {
+		h.logger.Info("circuit open, serving stale entry", "upstream", up.Name, "cache_key", state.cacheKey)
+		h.metrics.StaleHits.Inc()
+		h.writeCachedResponse(w, state.stale)
+		return
+	}
+
+	h.logger.Info("circuit open, serving fallback", "upstream", up.Name, "path", r.URL.Path)
+	fb := up.Breaker.Fallback()
+	for name, value := range fb.Headers {
+		w.Header().Set(name, value)
+	}
+	if wait := up.Breaker.RetryAfter(); wait > 0 && w.Header().Get("Retry-After") == "" {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+	w.WriteHeader(fb.Status)
+	w.Write([]byte(fb.Body))
+}
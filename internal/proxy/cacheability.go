@@ -0,0 +1,142 @@
+// File: internal/proxy/cacheability.go
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"go-caching-proxy/internal/route"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The rules in this file decide what may be served from and stored in the
+// cache. They are shared by the request path and by Explain, so the explain
+// endpoint always reports exactly what the proxy does.
+
+// requestCacheability decides whether a request can be answered from the
+// cache. For cacheable POSTs it returns the hash of the body for the key. If
+// the request bypasses the cache, reason says why.
+func (h *Handler) requestCacheability(r *http.Request, rt *route.Route) (bodyHash, reason string, err error) {
+	switch {
+	case rt != nil && rt.Bypass:
+		return "", fmt.Sprintf("route %q bypasses the cache", rt.Name), nil
+	case r.Method == http.MethodGet:
+		return "", "", nil
+	case r.Method == http.MethodPost && rt != nil && rt.CachePost:
+		bodyHash, err = hashRequestBody(r, rt)
+		if err != nil {
+			return "", "", err
+		}
+		if bodyHash == "" {
+			return "", fmt.Sprintf("request body exceeds the route's limit of %d bytes", rt.MaxBodyBytes), nil
+		}
+		return bodyHash, "", nil
+	case r.Method == http.MethodPost:
+		return "", "POST requests are only cached on routes with post caching enabled", nil
+	default:
+		return "", r.Method + " requests are not cached", nil
+	}
+}
+
+// responseCacheability judges a response by its status and headers. It
+// returns how long the response is cached and whether as a negative entry,
+// or the reasons it must not be stored. rt is the route the request matched;
+// a response that varies on a header its key doesn't include would otherwise
+// be served to clients that sent a different value.
+func (h *Handler) responseCacheability(resp *http.Response, rt *route.Route) (ttl time.Duration, negative bool, reasons []string) {
+	ttl, negative, reason := h.statusTTL(resp.StatusCode, rt)
+	if reason != "" {
+		reasons = append(reasons, reason)
+	}
+	if rt == nil || !rt.ForceCache {
+		cc := parseCacheControl(resp.Header)
+		if cc.has("no-store") {
+			reasons = append(reasons, "Cache-Control: no-store")
+		}
+		if cc.has("private") {
+			reasons = append(reasons, "Cache-Control: private")
+		}
+		if len(resp.Header.Values("Set-Cookie")) > 0 {
+			reasons = append(reasons, "response sets a cookie (Set-Cookie)")
+		}
+	}
+	keys := h.keysFor(rt)
+	for _, value := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			switch {
+			case name == "":
+			case strings.EqualFold(name, "Accept-Encoding"):
+				// Cacheable requests are sent without the client's
+				// Accept-Encoding (see direct), so the stored body suits
+				// every client.
+			case name == "*":
+				reasons = append(reasons, "Vary: * never matches a stored response")
+			case !keys.Template.VariesOn(name):
+				reasons = append(reasons, fmt.Sprintf("Vary: %s is not part of the cache key", name))
+			}
+		}
+	}
+	return ttl, negative, reasons
+}
+
+// readBody reads a response body of at most maxObjectBytes (0 means no
+// limit). If the body is larger, it is left intact on the response for the
+// client and tooLarge is set.
+func (h *Handler) readBody(resp *http.Response) (body []byte, tooLarge bool, err error) {
+	if h.maxObjectBytes <= 0 {
+		body, err = io.ReadAll(resp.Body)
+	} else {
+		body, err = io.ReadAll(io.LimitReader(resp.Body, h.maxObjectBytes+1))
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if h.maxObjectBytes > 0 && int64(len(body)) > h.maxObjectBytes {
+		resp.Body = prependBody(body, resp.Body)
+		return nil, true, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return body, false, nil
+}
+
+// tooLargeReason describes a body that exceeds the object size limit.
+func (h *Handler) tooLargeReason() string {
+	return fmt.Sprintf("body exceeds max_object_bytes (%d)", h.maxObjectBytes)
+}
+
+// prependBody stitches bytes already consumed from rc back in front of the
+// rest of it.
+func prependBody(consumed []byte, rc io.ReadCloser) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(consumed), rc), rc}
+}
+
+// cacheControl holds the directives of a Cache-Control header, names
+// lowercased, mapped to their (unquoted) arguments.
+type cacheControl map[string]string
+
+// parseCacheControl parses every Cache-Control header of h.
+func parseCacheControl(h http.Header) cacheControl {
+	cc := make(cacheControl)
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				cc[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return cc
+}
+
+// has reports whether the directive is present.
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
@@ -0,0 +1,166 @@
+// File: internal/proxy/explain.go
+package proxy
+
+import (
+	"context"
+	"go-caching-proxy/internal/route"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Explanation reports how the proxy treats a request, for debugging why a
+// response is or isn't served from the cache.
+type Explanation struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	// Route is the matched route, empty if the defaults apply. Upstream is
+	// the origin the request is sent to.
+	Route       string `json:"route,omitempty"`
+	Upstream    string `json:"upstream,omitempty"`
+	KeyTemplate string `json:"key_template"`
+	// Key is the cache key as built by the template, BackendKey the key
+	// actually used in the cache after namespacing and hashing.
+	Key        string `json:"key,omitempty"`
+	BackendKey string `json:"backend_key,omitempty"`
+
+	RequestCacheable bool   `json:"request_cacheable"`
+	RequestReason    string `json:"request_reason,omitempty"`
+
+	// Cached is set if the request would be answered from the cache,
+	// Negative if that answer is a cached error.
+	Cached     bool    `json:"cached"`
+	Negative   bool    `json:"negative,omitempty"`
+	TTLSeconds float64 `json:"ttl_seconds,omitempty"`
+	// Stale is set if only an expired entry is cached, which is served
+	// while the upstream's circuit breaker is open.
+	Stale bool `json:"stale,omitempty"`
+
+	// Fetch is the result of the dry-run fetch, if one was made.
+	Fetch *FetchVerdict `json:"fetch,omitempty"`
+}
+
+// FetchVerdict is the cacheability verdict on a response fetched from the
+// origin without storing it.
+type FetchVerdict struct {
+	StatusCode int  `json:"status_code,omitempty"`
+	Size       int  `json:"size"`
+	Cacheable  bool `json:"cacheable"`
+	// TTLSeconds is how long the response would be cached; Negative is set
+	// if it would be stored as a negative (error) entry.
+	TTLSeconds float64  `json:"ttl_seconds,omitempty"`
+	Negative   bool     `json:"negative,omitempty"`
+	Reasons    []string `json:"reasons,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Explain computes the key, route and cache status of r the same way
+// ServeHTTP does, without serving it. If fetch is set and the request is
+// cacheable, it is also sent to the origin (the response is discarded, never
+// cached) to judge whether the response would be stored.
+//
+// r must look like a request received by the server: a path-only URL and
+// the Host set.
+func (h *Handler) Explain(r *http.Request, fetch bool) *Explanation {
+	rt := h.routes.Match(r)
+	keys := h.keysFor(rt)
+	ex := &Explanation{
+		Method:      r.Method,
+		URL:         r.Host + r.URL.RequestURI(),
+		KeyTemplate: keys.Template.String(),
+	}
+	if rt != nil {
+		ex.Route = rt.Name
+	}
+	up := h.upstreams.Match(r)
+	if up == nil {
+		ex.RequestReason = "no upstream for this request"
+		return ex
+	}
+	ex.Upstream = up.Name
+	r = r.WithContext(context.WithValue(r.Context(), upstreamContextKey, up))
+
+	bodyHash, reason, err := h.requestCacheability(r, rt)
+	if err != nil {
+		ex.RequestReason = "failed to read request body: " + err.Error()
+		return ex
+	}
+	if reason != "" {
+		ex.RequestReason = reason
+		return ex
+	}
+	ex.RequestCacheable = true
+	ex.Key = keys.Generate(r)
+	if bodyHash != "" {
+		ex.Key += "|body:" + bodyHash
+	}
+	ex.Key += up.Credentials.KeySuffix()
+	ex.BackendKey = up.Encoder.Encode(ex.Key)
+
+	entry, found := h.cache.Get(ex.BackendKey)
+	switch {
+	case found && entry.Fresh(time.Now()):
+		ex.Cached = true
+		ex.TTLSeconds = time.Until(entry.ExpiresAt).Seconds()
+		if !entry.FreshUntil.IsZero() {
+			ex.TTLSeconds = time.Until(entry.FreshUntil).Seconds()
+		}
+	default:
+		ex.Stale = found
+		if entry, found := h.cache.Get(ex.BackendKey + negativeSuffix); found {
+			ex.Cached = true
+			ex.Negative = true
+			ex.TTLSeconds = time.Until(entry.ExpiresAt).Seconds()
+		}
+	}
+
+	if fetch {
+		ex.Fetch = h.dryRun(r, rt, ex.BackendKey)
+	}
+	return ex
+}
+
+// dryRun sends r once to one of its upstream's backends, directed the way
+// the reverse proxy would, and judges the response by the same rules as
+// modifyResponse. It is not retried or hedged, and its outcome counts
+// towards neither the backend's health nor the circuit breaker. While the
+// upstream's quota is low, nothing is sent.
+func (h *Handler) dryRun(r *http.Request, rt *route.Route, cacheKey string) *FetchVerdict {
+	up := upstreamFrom(r.Context())
+	if up.Quota.Low() {
+		return &FetchVerdict{Error: "upstream quota is low, not spending it on a dry run"}
+	}
+	state := &requestState{cacheKey: cacheKey, route: rt}
+	out := r.Clone(context.WithValue(r.Context(), requestStateContextKey, state))
+	out.RequestURI = ""
+	h.proxy.Director(out)
+
+	resp, err := up.Send(up.Pick(balanceKey(up, out)), out)
+	if err != nil {
+		return &FetchVerdict{Error: err.Error()}
+	}
+	defer resp.Body.Close()
+	h.observeQuota(up, resp)
+
+	v := &FetchVerdict{StatusCode: resp.StatusCode}
+	ttl, negative, reasons := h.responseCacheability(resp, rt)
+	v.Reasons = reasons
+	body, tooLarge, err := h.readBody(resp)
+	switch {
+	case err != nil:
+		v.Error = "failed to read response body: " + err.Error()
+	case tooLarge:
+		// The body was left on the response; drain it to report its size.
+		n, _ := io.Copy(io.Discard, resp.Body)
+		v.Size = int(n)
+		v.Reasons = append(v.Reasons, h.tooLargeReason())
+	default:
+		v.Size = len(body)
+	}
+	v.Cacheable = len(v.Reasons) == 0 && v.Error == ""
+	if v.Cacheable {
+		v.TTLSeconds = ttl.Seconds()
+		v.Negative = negative
+	}
+	return v
+}
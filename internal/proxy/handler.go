@@ -4,107 +4,402 @@ package proxy
 import (
 	"bytes"
 	"context"
+	"errors"
 	"go-caching-proxy/internal/cache"
-	"go-caching-proxy/internal/metrics"
 	"go-caching-proxy/internal/key"
+	"go-caching-proxy/internal/metrics"
+	"go-caching-proxy/internal/route"
+	"go-caching-proxy/internal/upstream"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
+	"strings"
 	"time"
 )
 
 // Use a custom type for our context key to avoid collisions.
 type contextKey string
 
-const cacheKeyContextKey = contextKey("cacheKey")
+const (
+	requestStateContextKey = contextKey("requestState")
+	upstreamContextKey     = contextKey("upstream")
+)
+
+// requestState carries what ServeHTTP worked out about a cacheable request
+// through the reverse proxy to modifyResponse.
+type requestState struct {
+	cacheKey string
+	// urlTag is attached to the stored entry so that every variant of the
+	// URL can be found again by PURGE and BAN.
+	urlTag string
+	// unsafe is the original request when it used an unsafe method; its
+	// response is never cached but may invalidate cached URLs.
+	unsafe *http.Request
+	// route is the matched route, if any, whose rules apply to the response.
+	route *route.Route
+	// stale is the expired entry for the key, if one is still kept, to be
+	// served if the origin can't be asked or can't be reached.
+	stale *cache.CacheEntry
+}
 
 type Handler struct {
-	target     *url.URL
+	upstreams  *upstream.Set
 	proxy      *httputil.ReverseProxy
 	cache      cache.Storer
 	defaultTTL time.Duration
 	logger     *slog.Logger
-	metrics *metrics.Metrics 
-}
+	metrics    *metrics.Metrics
 
-func NewHandler(target string, cache cache.Storer, defaultTTL time.Duration, logger *slog.Logger, mets *metrics.Metrics) (*Handler, error) {
-	targetURL, err := url.Parse(target)
-	if err != nil {
-		return nil, err
-	}
+	keys     *key.Builder
+	encoder  *key.Encoder
+	routes   *route.Table
+	purgeACL *PurgeACL
 
+	maxObjectBytes int64
+	negative       *NegativeTTLs
+	stale          time.Duration
+}
+
+// NewHandler creates the proxy handler. Requests go to target unless
+// WithUpstreams supplies named upstreams; target may then be empty.
+func NewHandler(target string, cache cache.Storer, defaultTTL time.Duration, logger *slog.Logger, mets *metrics.Metrics, opts ...Option) (*Handler, error) {
 	h := &Handler{
-		target:     targetURL,
 		cache:      cache,
 		defaultTTL: defaultTTL,
 		logger:     logger.With("component", "proxy_handler"),
-		metrics: mets,
+		metrics:    mets,
+		keys:       key.NewBuilder(nil, key.Normalizer{}),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.upstreams == nil {
+		set, err := upstream.Single(target, h.encoder)
+		if err != nil {
+			return nil, err
+		}
+		h.upstreams = set
+	}
+	for _, up := range h.upstreams.All() {
+		h.watchBreaker(up)
 	}
 
-	proxy := httputil.NewSingleHostReverseProxy(targetURL)
-	proxy.ModifyResponse = h.modifyResponse
-	h.proxy = proxy
+	// One reverse proxy serves every upstream: the upstream chosen in
+	// ServeHTTP travels in the request context to direct and roundTrip.
+	h.proxy = &httputil.ReverseProxy{
+		Director:       h.direct,
+		Transport:      roundTripperFunc(h.roundTrip),
+		ModifyResponse: h.modifyResponse,
+		ErrorHandler:   h.handleTransportError,
+	}
 
 	return h, nil
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	up := h.upstreams.Match(r)
+	if up == nil {
+		h.logger.Warn("no upstream for request", "host", r.Host, "path", r.URL.Path)
+		http.Error(w, "no upstream for this request", http.StatusBadGateway)
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), upstreamContextKey, up))
+
+	switch r.Method {
+	case MethodPurge, MethodBan:
+		h.servePurge(w, r)
+		return
+	}
+
+	rt := h.routes.Match(r)
+
+	// Only GET requests are cached by default. Routes can opt into caching
+	// POST requests, in which case the body becomes part of the key.
+	bodyHash, reason, err := h.requestCacheability(r, rt)
+	if err != nil {
+		h.logger.Warn("failed to read request body", "path", r.URL.Path, "error", err)
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if reason != "" {
+		if !h.protectQuota(w, r, up, nil) {
+			return
+		}
+		// A POST the route would cache (but whose body is too large to key
+		// on) is a read, not a write, and invalidates nothing.
+		if isUnsafe(r.Method) && (rt == nil || !rt.CachePost) {
+			state := &requestState{unsafe: r}
+			ctx := context.WithValue(r.Context(), requestStateContextKey, state)
+			h.proxy.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
 		h.proxy.ServeHTTP(w, r)
 		return
 	}
 
 	// === THE FIX - PART 1 ===
 	// Generate the key once here.
-	cacheKey := key.Generate(r)
-	log := h.logger.With("cache_key", cacheKey, "path", r.URL.Path)
+	cacheKey := h.cacheKey(up, r, rt, bodyHash)
+	log := h.logger.With("cache_key", cacheKey, "method", r.Method, "path", r.URL.Path, "upstream", up.Name)
+	if rt != nil {
+		log = log.With("route", rt.Name)
+	}
 
-	if entry, found := h.cache.Get(cacheKey); found {
+	entry, found := h.cache.Get(cacheKey)
+	if found && entry.Fresh(time.Now()) {
 		log.Info("cache hit")
 		h.metrics.CacheHits.Inc()
 		h.writeCachedResponse(w, entry)
 		return
 	}
+	var stale *cache.CacheEntry
+	if found {
+		stale = entry
+	}
+	if entry, found := h.cache.Get(cacheKey + negativeSuffix); found {
+		// While the origin is failing, the last good response beats the
+		// error it is failing with.
+		if stale != nil {
+			log.Info("negative entry cached, serving stale entry", "status", entry.StatusCode)
+			h.metrics.StaleHits.Inc()
+			h.writeCachedResponse(w, stale)
+			return
+		}
+		log.Info("negative cache hit", "status", entry.StatusCode)
+		h.metrics.NegativeHits.Inc()
+		h.writeCachedResponse(w, entry)
+		return
+	}
 
 	log.Info("cache miss")
-	h.metrics.CacheMisses.Inc() 
+	h.metrics.CacheMisses.Inc()
+	if !h.protectQuota(w, r, up, stale) {
+		return
+	}
 
 	// === THE FIX - PART 2 ===
 	// Store the consistent key in the request's context before forwarding it.
-	ctx := context.WithValue(r.Context(), cacheKeyContextKey, cacheKey)
+	state := &requestState{
+		cacheKey: cacheKey,
+		urlTag:   h.urlTag(up, r, h.keysFor(rt)),
+		route:    rt,
+		stale:    stale,
+	}
+	ctx := context.WithValue(r.Context(), requestStateContextKey, state)
 	h.proxy.ServeHTTP(w, r.WithContext(ctx))
 }
 
+// cacheKey builds the cache key for a request using the matched route's key
+// settings, or the handler's defaults if no route matched, and encodes it in
+// the upstream's namespace.
+func (h *Handler) cacheKey(up *upstream.Upstream, r *http.Request, rt *route.Route, bodyHash string) string {
+	raw := h.keysFor(rt).Generate(r)
+	if bodyHash != "" {
+		raw += "|body:" + bodyHash
+	}
+	return up.Encoder.Encode(raw + up.Credentials.KeySuffix())
+}
+
+// direct is the reverse proxy's Director: it rewrites the request for the
+// upstream chosen in ServeHTTP.
+func (h *Handler) direct(req *http.Request) {
+	up := upstreamFrom(req.Context())
+	if up == nil {
+		up = h.upstreams.Match(req)
+	}
+	up.Direct(req)
+
+	// A compressed body stored for one client would be served to clients
+	// that can't decode it. Without the client's Accept-Encoding, the
+	// transport asks for gzip itself and decompresses the response.
+	if state, ok := req.Context().Value(requestStateContextKey).(*requestState); ok && state.cacheKey != "" &&
+		!h.keysFor(state.route).Template.VariesOn("Accept-Encoding") {
+		req.Header.Del("Accept-Encoding")
+	}
+}
+
+// attempt picks one of the upstream's backends and sends the request to it
+// over the upstream's connections, once.
+func (h *Handler) attempt(up *upstream.Upstream, req *http.Request, key string) (*http.Response, error) {
+	if !up.Breaker.Allow() {
+		return nil, upstream.ErrCircuitOpen
+	}
+	b := up.Pick(key)
+	if !b.Healthy() {
+		h.logger.Warn("no healthy backend, trying an unhealthy one", "upstream", up.Name, "backend", b.Name())
+	}
+	h.logger.Info("forwarding to backend", "upstream", up.Name, "backend", b.Name(), "balance", up.Balance, "path", req.URL.Path)
+	h.metrics.BackendRequests.WithLabelValues(up.Name, b.Name()).Inc()
+
+	start := time.Now()
+	resp, err := up.Send(b, req)
+	latency := time.Since(start)
+	if err == nil {
+		up.Hedge.Observe(latency)
+		h.observeQuota(up, resp)
+	}
+	// A client going away, a hedged request being called off, or the
+	// OAuth2 token endpoint failing says nothing about the backend.
+	if err != nil && (req.Context().Err() != nil || errors.Is(err, upstream.ErrTokenUnavailable)) {
+		up.Breaker.Release()
+		return nil, err
+	}
+	failed := err != nil || resp.StatusCode >= 500
+	up.Breaker.Record(failed, latency)
+	if up.Observe(b, failed) {
+		h.logger.Warn("backend ejected after consecutive failures", "upstream", up.Name, "backend", b.Name(), "until", b.EjectedUntil())
+		h.metrics.BackendEjections.WithLabelValues(up.Name, b.Name()).Inc()
+	}
+	return resp, err
+}
+
+// balanceKey is the key backends are picked by: the cache key without its
+// namespace and generation, so that consistent hashing keeps sending a URL
+// to the same origin across flushes, or the request URI when the request
+// isn't cached.
+func balanceKey(up *upstream.Upstream, req *http.Request) string {
+	if state, ok := req.Context().Value(requestStateContextKey).(*requestState); ok && state.cacheKey != "" {
+		return strings.TrimPrefix(state.cacheKey, up.Encoder.Prefix())
+	}
+	return req.URL.RequestURI()
+}
+
+// upstreamFrom returns the upstream stored in the context by ServeHTTP.
+func upstreamFrom(ctx context.Context) *upstream.Upstream {
+	up, _ := ctx.Value(upstreamContextKey).(*upstream.Upstream)
+	return up
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Encoders returns the cache key encoder of every upstream, by name.
+func (h *Handler) Encoders() map[string]*key.Encoder {
+	encoders := make(map[string]*key.Encoder)
+	for _, up := range h.upstreams.All() {
+		encoders[up.Name] = up.Encoder
+	}
+	return encoders
+}
+
+// Upstreams returns every upstream, the default one last.
+func (h *Handler) Upstreams() []*upstream.Upstream {
+	return h.upstreams.All()
+}
+
+// keysFor returns the key builder for a route, or the handler's default.
+func (h *Handler) keysFor(rt *route.Route) *key.Builder {
+	if rt != nil && rt.Key != nil {
+		return rt.Key
+	}
+	return h.keys
+}
+
+// hashRequestBody reads a POST body and returns its hash for the cache key.
+// The body is always put back on the request so it can be replayed to the
+// origin. If the body exceeds the route's limit, it returns "" and the
+// request should not be cached.
+func hashRequestBody(r *http.Request, rt *route.Route) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return key.HashBody(nil, rt.CanonicalJSON), nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, rt.MaxBodyBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(body)) > rt.MaxBodyBytes {
+		// Stitch the part we already consumed back in front of the rest.
+		r.Body = prependBody(body, r.Body)
+		return "", nil
+	}
+
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return key.HashBody(body, rt.CanonicalJSON), nil
+}
+
 func (h *Handler) modifyResponse(resp *http.Response) error {
-	if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") == "no-store" {
-		return nil
+	// Tags are meant for the cache only; collect them and strip the headers
+	// before anything else sees the response.
+	tags := surrogateKeys(resp.Header)
+	stripSurrogateKeys(resp.Header)
+	// Neither clients nor the cache may see the upstream's credentials,
+	// even if the origin echoes them back.
+	if up := upstreamFrom(resp.Request.Context()); up != nil {
+		if scrubbed := up.Credentials.Scrub(resp.Header); len(scrubbed) > 0 {
+			h.logger.Warn("removed upstream credentials from response headers", "upstream", up.Name, "headers", scrubbed)
+		}
 	}
 
 	// === THE FIX - PART 3 ===
 	// Retrieve the consistent key from the context.
-	cacheKey, ok := resp.Request.Context().Value(cacheKeyContextKey).(string)
+	state, ok := resp.Request.Context().Value(requestStateContextKey).(*requestState)
 	if !ok {
 		// If the key is not in the context, something is wrong. Don't cache.
 		return nil
 	}
+	if state.unsafe != nil {
+		h.invalidate(state.unsafe, resp)
+		return nil
+	}
+
+	cacheKey := state.cacheKey
 	log := h.logger.With("cache_key", cacheKey, "status", resp.StatusCode)
 
-	body, err := io.ReadAll(resp.Body)
+	ttl, negative, reasons := h.responseCacheability(resp, state.route)
+	if len(reasons) > 0 {
+		log.Debug("response not cacheable", "reasons", reasons)
+		return nil
+	}
+
+	body, tooLarge, err := h.readBody(resp)
 	if err != nil {
 		log.Error("failed to read response body", "error", err)
 		return err
 	}
-	resp.Body = io.NopCloser(bytes.NewBuffer(body))
+	if tooLarge {
+		log.Debug("response not cacheable", "reasons", []string{h.tooLargeReason()})
+		return nil
+	}
 
+	headers := resp.Header.Clone()
+	if state.route != nil && state.route.ForceCache {
+		// A forced entry is shared by every client; never hand out one
+		// client's cookies to the others.
+		headers.Del("Set-Cookie")
+	}
 	entry := cache.CacheEntry{
 		StatusCode: resp.StatusCode,
-		Headers:    resp.Header.Clone(),
+		Headers:    headers,
 		Body:       body,
-		ExpiresAt:  time.Now().Add(h.defaultTTL),
+		ExpiresAt:  time.Now().Add(ttl),
+		StoredAt:   time.Now(),
+		Tags:       []string{state.urlTag},
+	}
+	if h.stale > 0 && !negative {
+		entry.FreshUntil = entry.ExpiresAt
+		entry.ExpiresAt = entry.ExpiresAt.Add(h.stale)
+	}
+	for _, tag := range tags {
+		entry.Tags = append(entry.Tags, tagKey(upstreamFrom(resp.Request.Context()), tag))
 	}
 
+	if negative {
+		// Stored apart from the positive entry; see negativeSuffix.
+		h.cache.Set(cacheKey+negativeSuffix, entry)
+		log.Info("error response cached", "ttl", ttl)
+		return nil
+	}
 	h.cache.Set(cacheKey, entry)
 	log.Info("response cached successfully")
 	return nil
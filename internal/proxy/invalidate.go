@@ -0,0 +1,76 @@
+// File: internal/proxy/invalidate.go
+package proxy
+
+import (
+	"go-caching-proxy/internal/cache"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// isUnsafe reports whether a method may change state on the origin (RFC 9110
+// section 9.2.1). Successful unsafe requests invalidate cached responses.
+func isUnsafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// invalidate implements RFC 9111 section 4.4: after a non-error response to an
+// unsafe request, the target URI and the URIs in Location and Content-Location
+// are invalidated, if they are on the same host. Every variant is removed by
+// purging the URL tag all entries carry. On backends without tags, only the
+// entry a plain GET would use is deleted.
+//
+// Locations are in the origin's URL space: a path or URL on the origin's own
+// host is mapped back through the upstream (re-adding a stripped prefix).
+func (h *Handler) invalidate(r *http.Request, resp *http.Response) {
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return
+	}
+	up := upstreamFrom(r.Context())
+
+	targets := []*url.URL{r.URL}
+	for _, name := range []string{"Location", "Content-Location"} {
+		value := resp.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		ref, err := url.Parse(value)
+		if err != nil {
+			continue
+		}
+		u := r.URL.ResolveReference(ref)
+		switch {
+		case ref.Host == "" && !strings.HasPrefix(ref.Path, "/"):
+			// Relative to the request URL, which is already ours.
+		case ref.Host == "" || up.IsBackendHost(u.Host):
+			u.Path = up.ExternalPath(u.Path)
+		case !strings.EqualFold(u.Host, r.Host):
+			continue // Never let an origin invalidate another host's entries.
+		}
+		targets = append(targets, u)
+	}
+
+	tagger, tagged := h.cache.(cache.Tagger)
+	for _, u := range targets {
+		tr := r.Clone(r.Context())
+		tr.Method = http.MethodGet
+		tr.URL = &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery}
+		tr.RequestURI = tr.URL.RequestURI()
+		rt := h.routes.Match(tr)
+
+		n := 1
+		if tagged {
+			n = tagger.PurgeTag(h.urlTag(up, tr, h.keysFor(rt)))
+		} else {
+			k := h.cacheKey(up, tr, rt, "")
+			h.cache.Delete(k)
+			h.cache.Delete(k + negativeSuffix)
+		}
+		h.logger.Info("invalidated after unsafe request",
+			"method", r.Method, "path", r.URL.Path, "invalidated", tr.URL.RequestURI(), "entries", n)
+	}
+}
@@ -0,0 +1,126 @@
+// File: internal/proxy/negative.go
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-caching-proxy/internal/cache"
+	"go-caching-proxy/internal/route"
+	"go-caching-proxy/internal/upstream"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// negativeSuffix is appended to a cache key to get the key of its negative
+// entry. Keeping negative entries apart means an error never overwrites a
+// good response that might still be useful (e.g. to serve stale on error).
+const negativeSuffix = "|negative"
+
+// NegativeTTLs configures negative caching: how long error responses and
+// transport failures are cached, so that while the origin is failing it
+// isn't hit again by every client.
+type NegativeTTLs struct {
+	status         map[int]time.Duration // Exact statuses, e.g. 503
+	class          map[int]time.Duration // Status classes, 4 for 4xx
+	transportError time.Duration
+}
+
+// NewNegativeTTLs parses TTLs in seconds keyed by exact status ("503") or by
+// status class ("5xx"). An exact status wins over its class; statuses matched
+// by neither are not cached. transportErrorSeconds is the TTL for connection
+// failures and timeouts, which are answered with 502 or 504.
+func NewNegativeTTLs(seconds map[string]int, transportErrorSeconds int) (*NegativeTTLs, error) {
+	n := &NegativeTTLs{
+		status:         make(map[int]time.Duration),
+		class:          make(map[int]time.Duration),
+		transportError: time.Duration(transportErrorSeconds) * time.Second,
+	}
+	for spec, secs := range seconds {
+		ttl := time.Duration(secs) * time.Second
+		if len(spec) == 3 && strings.HasSuffix(strings.ToLower(spec), "xx") && spec[0] >= '1' && spec[0] <= '5' {
+			n.class[int(spec[0]-'0')] = ttl
+			continue
+		}
+		status, err := strconv.Atoi(spec)
+		if err != nil || status < 100 || status > 599 {
+			return nil, fmt.Errorf("negative cache: %q is neither a status nor a status class like 5xx", spec)
+		}
+		if status == http.StatusOK {
+			return nil, errors.New("negative cache: 200 responses are cached normally")
+		}
+		n.status[status] = ttl
+	}
+	return n, nil
+}
+
+// ttl returns how long a response with the given status is negatively
+// cached, and false if it isn't.
+func (n *NegativeTTLs) ttl(status int) (time.Duration, bool) {
+	if n == nil {
+		return 0, false
+	}
+	ttl, ok := n.status[status]
+	if !ok {
+		ttl, ok = n.class[status/100]
+	}
+	return ttl, ok && ttl > 0
+}
+
+// statusTTL returns how long a response with the given status is cached and
+// whether that is a negative entry, or the reason it isn't cached at all.
+func (h *Handler) statusTTL(status int, rt *route.Route) (ttl time.Duration, negative bool, reason string) {
+	if status == http.StatusOK {
+		if rt != nil && rt.TTL > 0 {
+			return rt.TTL, false, ""
+		}
+		return h.defaultTTL, false, ""
+	}
+	if ttl, ok := h.negative.ttl(status); ok {
+		return ttl, true, ""
+	}
+	return 0, false, fmt.Sprintf("status %d is not cacheable", status)
+}
+
+// handleTransportError replaces the reverse proxy's default error handler.
+// Like it, it answers with 502 (or 504 for a timeout), but it also caches
+// that answer negatively so clients don't all retry the failing origin.
+// Requests with a stale entry get that instead, and nothing is cached; those
+// an open circuit breaker kept from the origin get a stale entry or the
+// fallback response.
+func (h *Handler) handleTransportError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, upstream.ErrCircuitOpen) {
+		h.serveCircuitOpen(w, r)
+		return
+	}
+	status := http.StatusBadGateway
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		status = http.StatusGatewayTimeout
+	}
+	h.logger.Warn("origin request failed", "method", r.Method, "path", r.URL.Path, "status", status, "error", err)
+
+	state, ok := r.Context().Value(requestStateContextKey).(*requestState)
+	if ok && state.stale != nil {
+		h.logger.Info("serving stale entry", "cache_key", state.cacheKey)
+		h.metrics.StaleHits.Inc()
+		h.writeCachedResponse(w, state.stale)
+		return
+	}
+	// A request the client gave up on, or a failure to get an OAuth2
+	// token, says nothing about the origin.
+	unrelated := errors.Is(err, context.Canceled) || errors.Is(err, upstream.ErrTokenUnavailable)
+	if ok && state.unsafe == nil && !unrelated && h.negative != nil && h.negative.transportError > 0 {
+		h.cache.Set(state.cacheKey+negativeSuffix, cache.CacheEntry{
+			StatusCode: status,
+			Headers:    http.Header{},
+			ExpiresAt:  time.Now().Add(h.negative.transportError),
+			StoredAt:   time.Now(),
+			Tags:       []string{state.urlTag},
+		})
+	}
+	w.WriteHeader(status)
+}
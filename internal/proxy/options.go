@@ -0,0 +1,76 @@
+// File: internal/proxy/options.go
+package proxy
+
+import (
+	"go-caching-proxy/internal/key"
+	"go-caching-proxy/internal/route"
+	"go-caching-proxy/internal/upstream"
+	"time"
+)
+
+// Option customizes a Handler created by NewHandler.
+type Option func(*Handler)
+
+// WithKeys sets the builder used for cache keys of requests that don't match
+// a route.
+func WithKeys(b *key.Builder) Option {
+	return func(h *Handler) {
+		h.keys = b
+	}
+}
+
+// WithRoutes sets the route table consulted for every request.
+func WithRoutes(t *route.Table) Option {
+	return func(h *Handler) {
+		h.routes = t
+	}
+}
+
+// WithMaxObjectBytes sets the largest response body that is cached. Larger
+// responses are streamed to the client without being stored. 0 means no
+// limit.
+func WithMaxObjectBytes(n int64) Option {
+	return func(h *Handler) {
+		h.maxObjectBytes = n
+	}
+}
+
+// WithStale keeps entries for d after they expire, so that they can still
+// be served when the origin is unavailable.
+func WithStale(d time.Duration) Option {
+	return func(h *Handler) {
+		h.stale = d
+	}
+}
+
+// WithNegativeCaching enables caching of error responses and transport
+// failures with the given TTLs.
+func WithNegativeCaching(n *NegativeTTLs) Option {
+	return func(h *Handler) {
+		h.negative = n
+	}
+}
+
+// WithUpstreams sets the upstreams requests are routed to, replacing the
+// single target passed to NewHandler.
+func WithUpstreams(s *upstream.Set) Option {
+	return func(h *Handler) {
+		h.upstreams = s
+	}
+}
+
+// WithPurgeACL sets who may send PURGE and BAN requests. Without it, both
+// methods are refused.
+func WithPurgeACL(acl *PurgeACL) Option {
+	return func(h *Handler) {
+		h.purgeACL = acl
+	}
+}
+
+// WithKeyEncoder sets the encoder that namespaces, versions and optionally
+// hashes cache keys before they reach the Storer.
+func WithKeyEncoder(e *key.Encoder) Option {
+	return func(h *Handler) {
+		h.encoder = e
+	}
+}
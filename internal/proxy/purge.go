@@ -0,0 +1,181 @@
+// File: internal/proxy/purge.go
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"go-caching-proxy/internal/cache"
+	"go-caching-proxy/internal/key"
+	"go-caching-proxy/internal/upstream"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Invalidation methods accepted on the proxy port, as sent by CMSs that are
+// used to Varnish-style caches.
+const (
+	// MethodPurge removes every cached variant of the request URL.
+	MethodPurge = "PURGE"
+
+	// MethodBan removes every cached URL on the request's host that matches a
+	// pattern: the regular expression in X-Ban-Regex, the prefix in
+	// X-Ban-Prefix, or, if neither header is set, the request path as prefix.
+	MethodBan = "BAN"
+)
+
+// PurgeACL decides which clients may send PURGE and BAN requests: those
+// connecting from an allowed network, or those presenting the token in an
+// X-Purge-Token or "Authorization: Bearer" header. A nil PurgeACL denies
+// everyone.
+type PurgeACL struct {
+	networks []*net.IPNet
+	token    string
+}
+
+// NewPurgeACL creates an ACL from CIDRs (a bare IP allows just that address)
+// and an optional token.
+func NewPurgeACL(cidrs []string, token string) (*PurgeACL, error) {
+	acl := &PurgeACL{token: token}
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("purge acl: %w", err)
+		}
+		acl.networks = append(acl.networks, network)
+	}
+	return acl, nil
+}
+
+// Allow reports whether the request may purge.
+func (a *PurgeACL) Allow(r *http.Request) bool {
+	if a == nil {
+		return false
+	}
+	if a.token != "" {
+		token := r.Header.Get("X-Purge-Token")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			token = bearer
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1 {
+			return true
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range a.networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlTag is the internal tag every entry is stored with, identifying its URL
+// independently of the method, headers or body that make up the rest of its
+// key.
+func (h *Handler) urlTag(up *upstream.Upstream, r *http.Request, keys *key.Builder) string {
+	return tagKey(up, urlTagPrefix+keys.Primary(r))
+}
+
+// servePurge handles PURGE and BAN requests and answers with a JSON summary.
+// They act on the namespace of the upstream the request itself is routed to.
+func (h *Handler) servePurge(w http.ResponseWriter, r *http.Request) {
+	up := upstreamFrom(r.Context())
+	log := h.logger.With("method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "upstream", up.Name)
+
+	if !h.purgeACL.Allow(r) {
+		log.Warn("purge request denied")
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "purge not allowed"})
+		return
+	}
+	tagger, ok := h.cache.(cache.Tagger)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": ErrTagsUnsupported.Error()})
+		return
+	}
+
+	// Entries were stored by GET requests, so look up their route as one.
+	gr := r.Clone(r.Context())
+	gr.Method = http.MethodGet
+	keys := h.keysFor(h.routes.Match(gr))
+
+	if r.Method == MethodPurge {
+		n := tagger.PurgeTag(h.urlTag(up, r, keys))
+		log.Info("purged url", "entries", n)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status": "ok",
+			"method": r.Method,
+			"url":    keys.Primary(r),
+			"purged": n,
+		})
+		return
+	}
+
+	// BAN: walk the URL tags of the request's host and purge the matches.
+	match, pattern, err := banMatcher(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	host := keys.Normalizer.Apply(r).Host
+	prefix := tagKey(up, urlTagPrefix+host+"/")
+
+	urls := []string{}
+	n := 0
+	for _, tag := range tagger.Tags(prefix) {
+		uri := "/" + strings.TrimPrefix(tag, prefix)
+		if match(uri) {
+			n += tagger.PurgeTag(tag)
+			urls = append(urls, host+uri)
+		}
+	}
+	log.Info("banned urls", "pattern", pattern, "urls", len(urls), "entries", n)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":  "ok",
+		"method":  r.Method,
+		"host":    host,
+		"pattern": pattern,
+		"urls":    urls,
+		"purged":  n,
+	})
+}
+
+// banMatcher builds the URL matcher for a BAN request.
+func banMatcher(r *http.Request) (func(uri string) bool, string, error) {
+	if expr := r.Header.Get("X-Ban-Regex"); expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid X-Ban-Regex: %w", err)
+		}
+		return re.MatchString, "regex:" + expr, nil
+	}
+	prefix := r.Header.Get("X-Ban-Prefix")
+	if prefix == "" {
+		prefix = r.URL.Path
+	}
+	return func(uri string) bool { return strings.HasPrefix(uri, prefix) }, "prefix:" + prefix, nil
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
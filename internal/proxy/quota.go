@@ -0,0 +1,76 @@
+// File: internal/proxy/quota.go
+package proxy
+
+import (
+	"go-caching-proxy/internal/cache"
+	"go-caching-proxy/internal/upstream"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// protectQuota decides whether a request may spend its upstream's rate-limit
+// quota. While the quota is low it answers the request itself, with the
+// stale entry for it if one is kept or else with a 429, and returns false.
+// With on_miss: queue, a request may instead wait for its turn first.
+func (h *Handler) protectQuota(w http.ResponseWriter, r *http.Request, up *upstream.Upstream, stale *cache.CacheEntry) bool {
+	if !up.Quota.Low() {
+		return true
+	}
+	log := h.logger.With("upstream", up.Name, "method", r.Method, "path", r.URL.Path)
+	if stale != nil {
+		log.Info("quota low, serving stale entry")
+		h.metrics.QuotaThrottled.WithLabelValues(up.Name, "stale").Inc()
+		h.metrics.StaleHits.Inc()
+		h.writeCachedResponse(w, stale)
+		return false
+	}
+
+	wait, ok := up.Quota.Reserve()
+	if !ok {
+		log.Warn("quota low, rejecting request", "retry_after", wait)
+		h.metrics.QuotaThrottled.WithLabelValues(up.Name, "rejected").Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+		http.Error(w, "upstream rate limit nearly exhausted, try again later", http.StatusTooManyRequests)
+		return false
+	}
+	if wait > 0 {
+		log.Info("quota low, queueing request", "wait", wait)
+		h.metrics.QuotaThrottled.WithLabelValues(up.Name, "queued").Inc()
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-r.Context().Done():
+			return false
+		case <-timer.C:
+		}
+	}
+	return true
+}
+
+// observeQuota updates an upstream's quota from an origin response, and
+// logs and exports it.
+func (h *Handler) observeQuota(up *upstream.Upstream, resp *http.Response) {
+	if !up.Quota.Enabled() {
+		return
+	}
+	wasLow := up.Quota.Low()
+	up.Quota.Observe(resp)
+	remaining, known := up.Quota.Remaining()
+	if known {
+		h.metrics.QuotaRemaining.WithLabelValues(up.Name).Set(float64(remaining))
+	}
+	low := up.Quota.Low()
+	switch {
+	case low && !wasLow:
+		h.logger.Warn("upstream quota low, protecting it", "upstream", up.Name, "remaining", remaining, "status", resp.StatusCode)
+	case !low && wasLow:
+		h.logger.Info("upstream quota recovered", "upstream", up.Name, "remaining", remaining)
+	}
+	gauge := 0.0
+	if low {
+		gauge = 1
+	}
+	h.metrics.QuotaLow.WithLabelValues(up.Name).Set(gauge)
+}
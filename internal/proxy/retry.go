@@ -0,0 +1,164 @@
+// File: internal/proxy/retry.go
+package proxy
+
+import (
+	"context"
+	"errors"
+	"go-caching-proxy/internal/upstream"
+	"io"
+	"net/http"
+	"time"
+)
+
+// roundTrip is the reverse proxy's Transport. It sends the request to the
+// upstream chosen in ServeHTTP, hedging it when it is slow, and retries it
+// with backoff when it fails and the upstream's retry policy allows.
+func (h *Handler) roundTrip(req *http.Request) (*http.Response, error) {
+	up := upstreamFrom(req.Context())
+	key := balanceKey(up, req)
+	up.Retry.Request()
+	retryable := up.Retry.Retryable(req)
+
+	for retry := 0; ; retry++ {
+		attemptReq := req
+		if retry > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err := h.send(up, attemptReq, key)
+		reason := retryReason(up, req, resp, err)
+		if reason == "" || !retryable || retry >= up.Retry.MaxRetries() {
+			return resp, err
+		}
+		if !up.Retry.AllowRetry() {
+			h.logger.Warn("retry budget exhausted", "upstream", up.Name, "path", req.URL.Path)
+			h.metrics.RetriesDenied.WithLabelValues(up.Name).Inc()
+			return resp, err
+		}
+
+		wait := up.Retry.Backoff(retry + 1)
+		h.logger.Info("retrying origin request", "upstream", up.Name, "path", req.URL.Path,
+			"reason", reason, "retry", retry+1, "backoff", wait)
+		h.metrics.Retries.WithLabelValues(up.Name, reason).Inc()
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retryReason says why a request is worth retrying ("error" or "status"),
+// or returns "" if it isn't.
+func retryReason(up *upstream.Upstream, req *http.Request, resp *http.Response, err error) string {
+	switch {
+	case err != nil:
+		if req.Context().Err() != nil || errors.Is(err, upstream.ErrCircuitOpen) {
+			return "" // The client is gone, or the upstream is known to be down.
+		}
+		if errors.Is(err, upstream.ErrTokenUnavailable) {
+			return "" // Not the backend's fault; retrying only hammers the token endpoint.
+		}
+		return "error"
+	case up.Retry.RetryStatus(resp.StatusCode):
+		return "status"
+	}
+	return ""
+}
+
+// send makes one attempt at a request, hedged if the upstream hedges and
+// enough response times are known to tell when the request is slow.
+func (h *Handler) send(up *upstream.Upstream, req *http.Request, key string) (*http.Response, error) {
+	if up.Hedge.Hedgeable(req) && req.Header.Get("Upgrade") == "" {
+		if delay, ok := up.Hedge.Delay(); ok {
+			return h.hedge(up, req, key, delay)
+		}
+	}
+	return h.attempt(up, req, key)
+}
+
+// hedge sends the request, and sends it again if no response has arrived
+// after delay. The first response wins and the other request is canceled.
+// An error only wins if no other request is still outstanding.
+func (h *Handler) hedge(up *upstream.Upstream, req *http.Request, key string, delay time.Duration) (*http.Response, error) {
+	type result struct {
+		resp *http.Response
+		err  error
+		i    int // Index into cancels; 1 is the hedged request
+	}
+	results := make(chan result, 2)
+	var cancels []context.CancelFunc
+	launch := func() {
+		ctx, cancel := context.WithCancel(req.Context())
+		i := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := h.attempt(up, req.WithContext(ctx), key)
+			results <- result{resp: resp, err: err, i: i}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	for {
+		select {
+		case <-timer.C:
+			pending++
+			h.logger.Debug("hedging slow origin request", "upstream", up.Name, "path", req.URL.Path, "after", delay)
+			h.metrics.Hedges.WithLabelValues(up.Name, "sent").Inc()
+			launch()
+
+		case res := <-results:
+			pending--
+			if res.err != nil && pending > 0 {
+				continue // The other request may still succeed.
+			}
+			for i, cancel := range cancels {
+				if i != res.i {
+					cancel()
+				}
+			}
+			if pending > 0 {
+				// Collect the loser so its connection is released.
+				go func() {
+					if loser := <-results; loser.resp != nil {
+						loser.resp.Body.Close()
+					}
+				}()
+			}
+			if res.err != nil {
+				cancels[res.i]()
+				return nil, res.err
+			}
+			if res.i == 1 {
+				h.metrics.Hedges.WithLabelValues(up.Name, "won").Inc()
+			}
+			// The winner's context must live until its body has been read.
+			res.resp.Body = &cancelBody{ReadCloser: res.resp.Body, cancel: cancels[res.i]}
+			return res.resp, nil
+		}
+	}
+}
+
+// cancelBody cancels a request's context once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
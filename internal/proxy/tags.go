@@ -0,0 +1,76 @@
+// File: internal/proxy/tags.go
+package proxy
+
+import (
+	"errors"
+	"go-caching-proxy/internal/cache"
+	"go-caching-proxy/internal/upstream"
+	"net/http"
+	"strings"
+)
+
+// ErrTagsUnsupported is returned when purging by tag on a cache backend that
+// doesn't keep a tag index (see cache.Tagger).
+var ErrTagsUnsupported = errors.New("cache backend does not support tags")
+
+// surrogateKeyHeaders are the response headers origins use to label responses
+// with tags. Surrogate-Key is space separated (Fastly), Cache-Tag is comma
+// separated (Cloudflare). Both are for the cache only and never reach clients.
+var surrogateKeyHeaders = []string{"Surrogate-Key", "Cache-Tag"}
+
+// urlTagPrefix starts the internal tags identifying an entry's URL (see
+// urlTag). Origins may not use it, or they could attach their responses to
+// other URLs' purges.
+const urlTagPrefix = "url:"
+
+// surrogateKeys collects the tags from a response's headers, without
+// duplicates, in the order they appear. Tags with the reserved urlTagPrefix
+// are dropped.
+func surrogateKeys(header http.Header) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, name := range surrogateKeyHeaders {
+		for _, value := range header.Values(name) {
+			for _, tag := range strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' }) {
+				if !seen[tag] && !strings.HasPrefix(tag, urlTagPrefix) {
+					seen[tag] = true
+					tags = append(tags, tag)
+				}
+			}
+		}
+	}
+	return tags
+}
+
+// stripSurrogateKeys removes the tag headers before a response is sent on.
+func stripSurrogateKeys(header http.Header) {
+	for _, name := range surrogateKeyHeaders {
+		header.Del(name)
+	}
+}
+
+// tagKey namespaces a tag the same way the upstream's cache keys are, so
+// that proxies and upstreams sharing one backend under different namespaces
+// don't purge each other.
+func tagKey(up *upstream.Upstream, tag string) string {
+	return up.Encoder.Prefix() + "tag:" + tag
+}
+
+// PurgeTag removes every cached entry carrying the given tag, in the
+// namespaces of all upstreams, and returns how many were removed.
+func (h *Handler) PurgeTag(tag string) (int, error) {
+	tagger, ok := h.cache.(cache.Tagger)
+	if !ok {
+		return 0, ErrTagsUnsupported
+	}
+	n := 0
+	seen := make(map[string]bool)
+	for _, up := range h.upstreams.All() {
+		if k := tagKey(up, tag); !seen[k] {
+			seen[k] = true
+			n += tagger.PurgeTag(k)
+		}
+	}
+	h.logger.Info("purged cache tag", "tag", tag, "entries", n)
+	return n, nil
+}
@@ -0,0 +1,215 @@
+// File: internal/route/route.go
+package route
+
+import (
+	"fmt"
+	"go-caching-proxy/internal/config"
+	"go-caching-proxy/internal/key"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Route holds the cache rules for a subset of requests.
+//
+// A route matches a request when every criterion it sets matches: the host
+// (exact, or "*.example.com" for any subdomain), the path (by prefix, glob
+// or regular expression) and the method.
+type Route struct {
+	Name       string
+	Host       string
+	PathPrefix string
+	PathGlob   *regexp.Regexp
+	PathRegex  *regexp.Regexp
+	Methods    []string
+
+	// TTL is how long responses are cached; 0 uses the handler's default.
+	TTL time.Duration
+
+	// ForceCache stores responses even when the origin marks them
+	// Cache-Control: no-store or private, or sets cookies. Set-Cookie
+	// headers are dropped from the stored copy.
+	ForceCache bool
+
+	// Bypass sends every request on this route straight to the origin,
+	// without looking in or writing to the cache.
+	Bypass bool
+
+	// Key builds cache keys for requests on this route.
+	Key *key.Builder
+
+	// CachePost enables caching of POST requests, keyed on a hash of the
+	// body (canonicalized first if CanonicalJSON is set). Bodies larger than
+	// MaxBodyBytes are not cached.
+	CachePost     bool
+	MaxBodyBytes  int64
+	CanonicalJSON bool
+}
+
+// DefaultMaxBodyBytes is the POST body size limit when a route doesn't set one.
+const DefaultMaxBodyBytes = 64 * 1024
+
+// Matches reports whether the route applies to the request.
+func (rt *Route) Matches(r *http.Request) bool {
+	if rt.Host != "" && !MatchHost(rt.Host, r.Host) {
+		return false
+	}
+	if !strings.HasPrefix(r.URL.Path, rt.PathPrefix) {
+		return false
+	}
+	if rt.PathGlob != nil && !rt.PathGlob.MatchString(r.URL.Path) {
+		return false
+	}
+	if rt.PathRegex != nil && !rt.PathRegex.MatchString(r.URL.Path) {
+		return false
+	}
+	return len(rt.Methods) == 0 || slices.Contains(rt.Methods, r.Method)
+}
+
+// MatchHost compares a host pattern with a Host header, ignoring case and
+// port. "*.example.com" matches any subdomain of example.com.
+func MatchHost(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix)
+	}
+	return host == pattern
+}
+
+// compileGlob turns a path glob into a regular expression. "*" matches within
+// one path segment, "**" across segments and "?" any single character other
+// than "/".
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case c == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// Table is an ordered list of routes. The first matching route wins.
+type Table struct {
+	routes []*Route
+}
+
+// New compiles the routes from the configuration. Each route's key settings
+// are layered on top of the global ones.
+func New(cfgs []config.Route, globalKey config.KeyConfig) (*Table, error) {
+	t := &Table{}
+	for i, c := range cfgs {
+		rt := &Route{
+			Name:          c.Name,
+			Host:          c.Host,
+			PathPrefix:    c.PathPrefix,
+			TTL:           time.Duration(c.TTLSeconds) * time.Second,
+			ForceCache:    c.ForceCache,
+			Bypass:        c.Bypass,
+			CachePost:     c.Post.Enabled,
+			MaxBodyBytes:  c.Post.MaxBodyBytes,
+			CanonicalJSON: c.Post.CanonicalJSON,
+		}
+		if rt.MaxBodyBytes <= 0 {
+			rt.MaxBodyBytes = DefaultMaxBodyBytes
+		}
+		if rt.Name == "" {
+			rt.Name = fmt.Sprintf("route-%d", i)
+		}
+		for _, m := range c.Methods {
+			rt.Methods = append(rt.Methods, strings.ToUpper(m))
+		}
+		if c.PathGlob != "" {
+			glob, err := compileGlob(c.PathGlob)
+			if err != nil {
+				return nil, fmt.Errorf("route %q: bad path_glob: %w", rt.Name, err)
+			}
+			rt.PathGlob = glob
+		}
+		if c.PathRegex != "" {
+			re, err := regexp.Compile(c.PathRegex)
+			if err != nil {
+				return nil, fmt.Errorf("route %q: bad path_regex: %w", rt.Name, err)
+			}
+			rt.PathRegex = re
+		}
+		kb, err := NewKeyBuilder(globalKey, &c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", rt.Name, err)
+		}
+		rt.Key = kb
+		t.routes = append(t.routes, rt)
+	}
+	return t, nil
+}
+
+// Match returns the first route that applies to the request, or nil if none
+// does. A nil Table matches nothing.
+func (t *Table) Match(r *http.Request) *Route {
+	if t == nil {
+		return nil
+	}
+	for _, rt := range t.routes {
+		if rt.Matches(r) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// NewKeyBuilder compiles key settings into a key.Builder. If override is not
+// nil, its settings are layered on top of global (see config.KeyConfig).
+func NewKeyBuilder(global config.KeyConfig, override *config.KeyConfig) (*key.Builder, error) {
+	kc := global
+	if override != nil {
+		if override.Template != "" {
+			kc.Template = override.Template
+		}
+		if len(override.KeepParams) > 0 {
+			kc.KeepParams = override.KeepParams
+		}
+		kc.IgnoreParams = append(slices.Clone(global.IgnoreParams), override.IgnoreParams...)
+		kc.SortQuery = kc.SortQuery || override.SortQuery
+		kc.LowercaseHost = kc.LowercaseHost || override.LowercaseHost
+		kc.CollapseSlashes = kc.CollapseSlashes || override.CollapseSlashes
+	}
+
+	var tmpl *key.Template
+	if kc.Template != "" {
+		var err error
+		if tmpl, err = key.ParseTemplate(kc.Template); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range append(slices.Clone(kc.IgnoreParams), kc.KeepParams...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad query parameter pattern %q: %w", p, err)
+		}
+	}
+
+	return key.NewBuilder(tmpl, key.Normalizer{
+		SortQuery:       kc.SortQuery,
+		IgnoreParams:    kc.IgnoreParams,
+		KeepParams:      kc.KeepParams,
+		LowercaseHost:   kc.LowercaseHost,
+		CollapseSlashes: kc.CollapseSlashes,
+	}), nil
+}
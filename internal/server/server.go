@@ -15,8 +15,9 @@ import (
 
 // Server is the main struct for our web server.
 type Server struct {
-	port   string
-	logger *slog.Logger
+	port       string
+	logger     *slog.Logger
+	onShutdown []func()
 }
 
 // New creates a new instance of our server.
@@ -27,6 +28,12 @@ func New(port string, logger *slog.Logger) *Server {
 	}
 }
 
+// OnShutdown registers a function to run after in-flight requests have
+// finished during a graceful shutdown, e.g. to persist the cache.
+func (s *Server) OnShutdown(fn func()) {
+	s.onShutdown = append(s.onShutdown, fn)
+}
+
 // Start runs the HTTP server and includes the graceful shutdown logic.
 func (s *Server) Start(handler http.Handler) error {
 	srv := &http.Server{
@@ -56,11 +63,19 @@ func (s *Server) Start(handler http.Handler) error {
 	defer cancel()
 
 	// Attempt to gracefully shut down the server.
-	if err := srv.Shutdown(timeoutCtx); err != nil {
+	err := srv.Shutdown(timeoutCtx)
+
+	// Run the shutdown hooks even if some requests were cut off, so that
+	// state such as the cache snapshot is still saved.
+	for _, fn := range s.onShutdown {
+		fn()
+	}
+
+	if err != nil {
 		s.logger.Error("graceful shutdown failed", "error", err)
 		return err
 	}
 
 	s.logger.Info("server has shut down gracefully")
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,135 @@
+// File: internal/upstream/backend.go
+package upstream
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backend is one origin server of an upstream. Requests sent through it are
+// counted while in flight, for the least-connections strategy, and a backend
+// marked unhealthy is skipped by every strategy.
+//
+// A backend is unhealthy while any of three sources says so: an operator
+// (SetHealthy), the active probes (failing), or passive outlier detection
+// (ejected until some time).
+type Backend struct {
+	URL    *url.URL
+	Weight int
+
+	transport http.RoundTripper
+	director  func(*http.Request)
+	inFlight  atomic.Int64
+
+	disabled     atomic.Bool  // Taken out of rotation by an operator
+	failing      atomic.Bool  // Failing its active probes
+	ejectedUntil atomic.Int64 // Unix nanoseconds; ejected by passive checks
+	failures     atomic.Int64 // Consecutive failed live requests
+}
+
+// newBackend creates a backend for rawURL sending requests over transport.
+func newBackend(rawURL string, weight int, transport http.RoundTripper) (*Backend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("backend %q must be an absolute URL", rawURL)
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	return &Backend{
+		URL:       u,
+		Weight:    weight,
+		transport: transport,
+		director:  httputil.NewSingleHostReverseProxy(u).Director,
+	}, nil
+}
+
+// Name identifies the backend in logs, metrics and the admin API.
+func (b *Backend) Name() string {
+	return b.URL.Host
+}
+
+// Healthy reports whether the backend is in rotation.
+func (b *Backend) Healthy() bool {
+	return !b.disabled.Load() && !b.failing.Load() && !b.Ejected()
+}
+
+// SetHealthy puts the backend in or out of rotation by hand and reports
+// whether that changed the operator's setting. Health checks can still take
+// an enabled backend out of rotation.
+func (b *Backend) SetHealthy(healthy bool) bool {
+	return b.disabled.Swap(!healthy) != !healthy
+}
+
+// Disabled reports whether an operator took the backend out of rotation.
+func (b *Backend) Disabled() bool {
+	return b.disabled.Load()
+}
+
+// Failing reports whether the backend is failing its active probes.
+func (b *Backend) Failing() bool {
+	return b.failing.Load()
+}
+
+// Ejected reports whether passive outlier detection took the backend out
+// of rotation.
+func (b *Backend) Ejected() bool {
+	return time.Now().UnixNano() < b.ejectedUntil.Load()
+}
+
+// EjectedUntil returns when the current ejection ends, or the zero time.
+func (b *Backend) EjectedUntil() time.Time {
+	if !b.Ejected() {
+		return time.Time{}
+	}
+	return time.Unix(0, b.ejectedUntil.Load())
+}
+
+// InFlight returns the number of requests whose response is still being
+// read from this backend.
+func (b *Backend) InFlight() int64 {
+	return b.inFlight.Load()
+}
+
+// RoundTrip sends a request, already directed by Upstream.Direct, to this
+// backend. The request counts as in flight until its response body is
+// closed.
+func (b *Backend) RoundTrip(req *http.Request) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	b.director(out)
+
+	b.inFlight.Add(1)
+	resp, err := b.transport.RoundTrip(out)
+	if err != nil {
+		b.inFlight.Add(-1)
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// The reverse proxy needs the upgraded connection's body as it is,
+		// and a tunnel isn't a request in the balancing sense.
+		b.inFlight.Add(-1)
+		return resp, nil
+	}
+	resp.Body = &countedBody{ReadCloser: resp.Body, done: sync.OnceFunc(func() { b.inFlight.Add(-1) })}
+	return resp, nil
+}
+
+// countedBody calls done once when the body is closed.
+type countedBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (c *countedBody) Close() error {
+	c.done()
+	return c.ReadCloser.Close()
+}
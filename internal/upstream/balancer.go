@@ -0,0 +1,147 @@
+// File: internal/upstream/balancer.go
+package upstream
+
+import (
+	"fmt"
+	"go-caching-proxy/internal/hashring"
+	"sync"
+	"sync/atomic"
+)
+
+// Load balancing strategies.
+const (
+	RoundRobin       = "round_robin"
+	LeastConnections = "least_connections"
+	Weighted         = "weighted"
+	ConsistentHash   = "consistent_hash"
+)
+
+// Balancer picks the backend for each request.
+type Balancer interface {
+	// Pick returns the backend for a request with the given key (the cache
+	// key, or the request URI if the request isn't cacheable). Unhealthy
+	// backends are skipped unless every backend is unhealthy, in which case
+	// trying one beats failing outright.
+	Pick(key string) *Backend
+}
+
+// NewBalancer creates the balancer for a strategy ("" is round robin).
+func NewBalancer(strategy string, backends []*Backend) (Balancer, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no backends to balance")
+	}
+	switch strategy {
+	case "", RoundRobin:
+		return &roundRobin{backends: backends}, nil
+	case LeastConnections:
+		return &leastConnections{backends: backends}, nil
+	case Weighted:
+		return &weighted{backends: backends, current: make([]int, len(backends))}, nil
+	case ConsistentHash:
+		return newConsistentHash(backends), nil
+	default:
+		return nil, fmt.Errorf("unknown balance strategy %q", strategy)
+	}
+}
+
+// candidates returns the healthy backends, or all of them if none is.
+func candidates(backends []*Backend) []*Backend {
+	healthy := make([]*Backend, 0, len(backends))
+	for _, b := range backends {
+		if b.Healthy() {
+			healthy = append(healthy, b)
+		}
+	}
+	if len(healthy) == 0 {
+		return backends
+	}
+	return healthy
+}
+
+// roundRobin takes the healthy backends in turn.
+type roundRobin struct {
+	backends []*Backend
+	next     atomic.Uint64
+}
+
+func (b *roundRobin) Pick(string) *Backend {
+	c := candidates(b.backends)
+	return c[(b.next.Add(1)-1)%uint64(len(c))]
+}
+
+// leastConnections picks the backend with the fewest requests in flight.
+// Ties go round robin, so an idle upstream still spreads its requests.
+type leastConnections struct {
+	backends []*Backend
+	next     atomic.Uint64
+}
+
+func (b *leastConnections) Pick(string) *Backend {
+	c := candidates(b.backends)
+	start := int((b.next.Add(1) - 1) % uint64(len(c)))
+	best := c[start]
+	for i := 1; i < len(c); i++ {
+		if cand := c[(start+i)%len(c)]; cand.InFlight() < best.InFlight() {
+			best = cand
+		}
+	}
+	return best
+}
+
+// weighted is nginx's smooth weighted round robin: a backend with weight 3
+// gets three of every four requests next to one with weight 1, interleaved
+// rather than in bursts.
+type weighted struct {
+	backends []*Backend
+
+	mu      sync.Mutex
+	current []int // Per backend, parallel to backends
+}
+
+func (b *weighted) Pick(string) *Backend {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	anyHealthy := false
+	for _, be := range b.backends {
+		anyHealthy = anyHealthy || be.Healthy()
+	}
+	best, total := -1, 0
+	for i, be := range b.backends {
+		if anyHealthy && !be.Healthy() {
+			continue
+		}
+		b.current[i] += be.Weight
+		total += be.Weight
+		if best < 0 || b.current[i] > b.current[best] {
+			best = i
+		}
+	}
+	b.current[best] -= total
+	return b.backends[best]
+}
+
+// consistentHash sends each key to the same backend, so every origin's own
+// cache only sees its share of the keys. When a backend is ejected, only its
+// keys move, to the next backends on the ring.
+type consistentHash struct {
+	ring   *hashring.Ring
+	byName map[string]*Backend
+}
+
+func newConsistentHash(backends []*Backend) *consistentHash {
+	b := &consistentHash{ring: hashring.New(0), byName: make(map[string]*Backend, len(backends))}
+	for _, be := range backends {
+		id := be.URL.String()
+		b.byName[id] = be
+		b.ring.Add(id, be.Weight)
+	}
+	return b
+}
+
+func (b *consistentHash) Pick(key string) *Backend {
+	if id := b.ring.GetFunc(key, func(id string) bool { return b.byName[id].Healthy() }); id != "" {
+		return b.byName[id]
+	}
+	return b.byName[b.ring.Get(key)]
+}
@@ -0,0 +1,249 @@
+// File: internal/upstream/breaker.go
+package upstream
+
+import (
+	"errors"
+	"go-caching-proxy/internal/config"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned instead of sending a request to an upstream
+// whose circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+const (
+	defaultBreakerWindow      = 10 * time.Second
+	defaultBreakerMinRequests = 20
+	defaultBreakerOpen        = 30 * time.Second
+	defaultHalfOpenRequests   = 3
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	// Closed lets every request through while watching the outcomes.
+	Closed BreakerState = iota
+	// HalfOpen lets a few probe requests through to see if the upstream
+	// recovered.
+	HalfOpen
+	// Open rejects every request.
+	Open
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case HalfOpen:
+		return "half_open"
+	case Open:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker is an upstream's circuit breaker. It opens when, over a rolling
+// window, the share of failed (5xx or connection error) or slow requests
+// crosses its threshold. After a while it half-opens and lets a few probes
+// through: if they all succeed it closes, if any fails it opens again.
+type Breaker struct {
+	errorRate   float64
+	slowRate    float64
+	slow        time.Duration
+	minRequests int
+	openFor     time.Duration
+	probes      int
+	fallback    config.Fallback
+
+	mu        sync.Mutex
+	state     BreakerState
+	buckets   []breakerBucket // One per second of the window
+	openUntil time.Time
+	sent      int // Half-open probes sent
+	succeeded int // Half-open probes that succeeded
+	onChange  func(from, to BreakerState)
+}
+
+// breakerBucket counts the outcomes of one second.
+type breakerBucket struct {
+	second               int64
+	total, failed, slowN int
+}
+
+// NewBreaker creates the circuit breaker for an upstream. It never opens if
+// neither an error rate nor a slow rate is configured.
+func NewBreaker(c config.CircuitBreaker) *Breaker {
+	window := defaultBreakerWindow
+	if c.WindowSeconds > 0 {
+		window = time.Duration(c.WindowSeconds) * time.Second
+	}
+	b := &Breaker{
+		errorRate:   c.ErrorRate,
+		slowRate:    c.SlowRate,
+		slow:        time.Duration(c.SlowMS) * time.Millisecond,
+		minRequests: valueOr(c.MinRequests, defaultBreakerMinRequests),
+		openFor:     defaultBreakerOpen,
+		probes:      valueOr(c.HalfOpenRequests, defaultHalfOpenRequests),
+		fallback:    c.Fallback,
+		buckets:     make([]breakerBucket, int(window/time.Second)),
+	}
+	if c.OpenSeconds > 0 {
+		b.openFor = time.Duration(c.OpenSeconds) * time.Second
+	}
+	if b.fallback.Status == 0 {
+		b.fallback.Status = http.StatusServiceUnavailable
+	}
+	return b
+}
+
+// Enabled reports whether the breaker can ever open.
+func (b *Breaker) Enabled() bool {
+	return b.errorRate > 0 || (b.slowRate > 0 && b.slow > 0)
+}
+
+// OnStateChange registers a function called, with the lock held, whenever
+// the breaker changes state.
+func (b *Breaker) OnStateChange(fn func(from, to BreakerState)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onChange = fn
+}
+
+// State returns the breaker's current state.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// RetryAfter returns how long the breaker stays open, or 0.
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != Open {
+		return 0
+	}
+	return max(time.Until(b.openUntil), 0)
+}
+
+// Fallback returns the response to send while the breaker is open and
+// nothing stale is cached.
+func (b *Breaker) Fallback() config.Fallback {
+	return b.fallback
+}
+
+// Allow reports whether a request may be sent to the upstream. Every
+// allowed request must be followed by Record or Release.
+func (b *Breaker) Allow() bool {
+	if !b.Enabled() {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if time.Now().Before(b.openUntil) {
+			return false
+		}
+		b.setState(HalfOpen)
+		fallthrough
+	case HalfOpen:
+		if b.sent >= b.probes {
+			return false
+		}
+		b.sent++
+	}
+	return true
+}
+
+// Record reports the outcome of an allowed request.
+func (b *Breaker) Record(failed bool, latency time.Duration) {
+	if !b.Enabled() {
+		return
+	}
+	slow := b.slow > 0 && latency >= b.slow
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case HalfOpen:
+		switch {
+		case failed || slow:
+			b.open()
+		default:
+			b.succeeded++
+			if b.succeeded >= b.probes {
+				clear(b.buckets)
+				b.setState(Closed)
+			}
+		}
+	case Closed:
+		now := time.Now().Unix()
+		bucket := &b.buckets[now%int64(len(b.buckets))]
+		if bucket.second != now {
+			*bucket = breakerBucket{second: now}
+		}
+		bucket.total++
+		if failed {
+			bucket.failed++
+		}
+		if slow {
+			bucket.slowN++
+		}
+		if b.tripped(now) {
+			b.open()
+		}
+	}
+}
+
+// Release gives back an allowed request whose outcome says nothing about the
+// upstream, e.g. because the client went away.
+func (b *Breaker) Release() {
+	if !b.Enabled() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == HalfOpen && b.sent > 0 {
+		b.sent--
+	}
+}
+
+// tripped reports whether the outcomes over the window call for opening.
+// Must be called with the lock held.
+func (b *Breaker) tripped(now int64) bool {
+	var total, failed, slow int
+	for _, bucket := range b.buckets {
+		if now-bucket.second < int64(len(b.buckets)) {
+			total += bucket.total
+			failed += bucket.failed
+			slow += bucket.slowN
+		}
+	}
+	if total < b.minRequests {
+		return false
+	}
+	return (b.errorRate > 0 && float64(failed) >= b.errorRate*float64(total)) ||
+		(b.slowRate > 0 && b.slow > 0 && float64(slow) >= b.slowRate*float64(total))
+}
+
+// open opens the breaker. Must be called with the lock held.
+func (b *Breaker) open() {
+	b.openUntil = time.Now().Add(b.openFor)
+	b.setState(Open)
+}
+
+// setState switches state and notifies. Must be called with the lock held.
+func (b *Breaker) setState(s BreakerState) {
+	if s == b.state {
+		return
+	}
+	from := b.state
+	b.state = s
+	b.sent, b.succeeded = 0, 0
+	if b.onChange != nil {
+		b.onChange(from, s)
+	}
+}
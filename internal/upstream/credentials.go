@@ -0,0 +1,299 @@
+// File: internal/upstream/credentials.go
+package upstream
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-caching-proxy/internal/config"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// secretRecheck is how often a file secret is checked for changes.
+	secretRecheck = 5 * time.Second
+	// minScrubLength is the shortest credential looked for in response
+	// headers; shorter values, like "1" or "v2", would match by chance.
+	minScrubLength = 8
+)
+
+var defaultStripHeaders = []string{"Authorization", "Proxy-Authorization"}
+
+// Credentials are the headers and query parameters added to every request
+// sent to an upstream, and the OAuth2 tokens added by Upstream.Send. A nil
+// *Credentials adds nothing.
+type Credentials struct {
+	headers map[string]*secret // By canonical header name
+	query   map[string]*secret
+	tokens  *TokenSource
+	account string // Identifies the OAuth2 client in cache keys
+	strip   []string
+	inKey   bool
+}
+
+// NewCredentials loads the credentials of an upstream, or returns nil if
+// none are configured. Every file and environment variable must be set.
+func NewCredentials(c config.Credentials) (*Credentials, error) {
+	if len(c.Headers) == 0 && c.BearerToken.IsZero() && len(c.Query) == 0 && c.OAuth2.TokenURL == "" && len(c.StripHeaders) == 0 {
+		return nil, nil
+	}
+	cr := &Credentials{
+		headers: make(map[string]*secret),
+		query:   make(map[string]*secret),
+		strip:   c.StripHeaders,
+		inKey:   c.InKey,
+	}
+	for name, s := range c.Headers {
+		sec, err := newSecret(s, "")
+		if err != nil {
+			return nil, fmt.Errorf("credentials header %q: %w", name, err)
+		}
+		cr.headers[http.CanonicalHeaderKey(name)] = sec
+	}
+	if !c.BearerToken.IsZero() {
+		if _, ok := cr.headers["Authorization"]; ok {
+			return nil, errors.New("credentials: bearer_token and an Authorization header are both set")
+		}
+		sec, err := newSecret(c.BearerToken, "Bearer ")
+		if err != nil {
+			return nil, fmt.Errorf("credentials bearer_token: %w", err)
+		}
+		cr.headers["Authorization"] = sec
+	}
+	for name, s := range c.Query {
+		sec, err := newSecret(s, "")
+		if err != nil {
+			return nil, fmt.Errorf("credentials query %q: %w", name, err)
+		}
+		cr.query[name] = sec
+	}
+	if c.OAuth2.TokenURL != "" {
+		if _, ok := cr.headers["Authorization"]; ok {
+			return nil, errors.New("credentials: oauth2 and another Authorization header are both set")
+		}
+		tokens, err := NewTokenSource(c.OAuth2)
+		if err != nil {
+			return nil, fmt.Errorf("credentials: %w", err)
+		}
+		cr.tokens = tokens
+		cr.account = c.OAuth2.TokenURL + " " + c.OAuth2.ClientID + " " + strings.Join(c.OAuth2.Scopes, " ")
+	}
+	if len(cr.strip) == 0 && (len(cr.headers) > 0 || len(cr.query) > 0 || cr.tokens != nil) {
+		cr.strip = defaultStripHeaders
+	}
+	return cr, nil
+}
+
+// Apply removes the client's own credentials from an outgoing request and
+// adds the upstream's.
+func (c *Credentials) Apply(req *http.Request) {
+	if c == nil {
+		return
+	}
+	for _, name := range c.strip {
+		req.Header.Del(name)
+	}
+	for name, s := range c.headers {
+		req.Header.Set(name, s.Get())
+	}
+	if len(c.query) > 0 {
+		q := req.URL.Query()
+		for name, s := range c.query {
+			q.Set(name, s.Get())
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+}
+
+// Tokens returns the OAuth2 token source, or nil if OAuth2 is off.
+func (c *Credentials) Tokens() *TokenSource {
+	if c == nil {
+		return nil
+	}
+	return c.tokens
+}
+
+// Scrub removes the credentials from an origin's response headers, for
+// origins that echo them back, e.g. in a redirect's Location. Injected query
+// parameters are taken out of Location and Content-Location; any other
+// header containing a credential is dropped. It returns the names of the
+// headers it changed.
+func (c *Credentials) Scrub(h http.Header) []string {
+	if c == nil {
+		return nil
+	}
+	var secrets []string
+	for _, s := range c.all() {
+		if v := s.raw(); len(v) >= minScrubLength {
+			secrets = append(secrets, v)
+		}
+	}
+	if c.tokens != nil {
+		if v := c.tokens.current(); len(v) >= minScrubLength {
+			secrets = append(secrets, v)
+		}
+	}
+	if len(secrets) == 0 {
+		return nil
+	}
+	leaks := func(v string) bool {
+		return slices.ContainsFunc(secrets, func(s string) bool { return strings.Contains(v, s) })
+	}
+
+	var changed []string
+	for name, values := range h {
+		if !slices.ContainsFunc(values, leaks) {
+			continue
+		}
+		changed = append(changed, name)
+		if name == "Location" || name == "Content-Location" {
+			for i, v := range values {
+				values[i] = c.stripQuery(v)
+			}
+			if !slices.ContainsFunc(values, leaks) {
+				continue
+			}
+		}
+		h.Del(name)
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// stripQuery removes the injected query parameters from a URL.
+func (c *Credentials) stripQuery(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || len(c.query) == 0 {
+		return rawURL
+	}
+	q := u.Query()
+	for name := range c.query {
+		q.Del(name)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// KeySuffix returns what is added to cache keys: nothing, unless InKey is
+// configured, in which case a hash of the current credentials, never the
+// credentials themselves. OAuth2 tokens change all the time; the client
+// they are issued to is hashed instead.
+func (c *Credentials) KeySuffix() string {
+	if c == nil || !c.inKey {
+		return ""
+	}
+	var parts []string
+	for name, s := range c.headers {
+		parts = append(parts, "h:"+name+"="+s.Get())
+	}
+	for name, s := range c.query {
+		parts = append(parts, "q:"+name+"="+s.Get())
+	}
+	if c.account != "" {
+		parts = append(parts, "o:"+c.account)
+	}
+	slices.Sort(parts)
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return "|cred:" + hex.EncodeToString(sum[:8])
+}
+
+// all returns every secret.
+func (c *Credentials) all() []*secret {
+	all := make([]*secret, 0, len(c.headers)+len(c.query))
+	for _, s := range c.headers {
+		all = append(all, s)
+	}
+	for _, s := range c.query {
+		all = append(all, s)
+	}
+	return all
+}
+
+// secret is one credential. Inline and environment values are read once;
+// files are re-read when their modification time changes, so that rotated
+// tokens are picked up without a restart.
+type secret struct {
+	prefix string
+	file   string
+
+	mu      sync.Mutex
+	value   string
+	modTime time.Time
+	checked time.Time
+}
+
+// newSecret loads a secret, which Get returns after prefix.
+func newSecret(c config.Secret, prefix string) (*secret, error) {
+	sources := 0
+	for _, src := range []string{c.Value, c.File, c.Env} {
+		if src != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, errors.New("exactly one of value, file and env must be set")
+	}
+	s := &secret{prefix: prefix, file: c.File, value: c.Value}
+	switch {
+	case c.Env != "":
+		v, ok := os.LookupEnv(c.Env)
+		if !ok || v == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", c.Env)
+		}
+		s.value = v
+	case c.File != "":
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Get returns the prefixed value, re-reading the file if it changed. If it
+// can no longer be read, the last value is kept.
+func (s *secret) Get() string {
+	return s.prefix + s.raw()
+}
+
+// raw returns the value without its prefix.
+func (s *secret) raw() string {
+	if s.file == "" {
+		return s.value
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.checked) >= secretRecheck {
+		s.load()
+	}
+	return s.value
+}
+
+// load reads the file if it changed since it was last read. Must be called
+// with the lock held, or before the secret is shared.
+func (s *secret) load() error {
+	s.checked = time.Now()
+	info, err := os.Stat(s.file)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) && s.value != "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		return err
+	}
+	v := strings.TrimSpace(string(data))
+	if v == "" {
+		return fmt.Errorf("%s is empty", s.file)
+	}
+	s.value, s.modTime = v, info.ModTime()
+	return nil
+}
@@ -0,0 +1,223 @@
+// File: internal/upstream/health.go
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"go-caching-proxy/internal/metrics"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProbeInterval      = 10 * time.Second
+	defaultProbeTimeout       = 2 * time.Second
+	defaultHealthyThreshold   = 2
+	defaultUnhealthyThreshold = 3
+	defaultEjectDuration      = 30 * time.Second
+
+	// healthReportInterval is how often backend health is published to
+	// Prometheus and changes are logged, which also catches ejections
+	// ending and operators disabling backends.
+	healthReportInterval = time.Second
+)
+
+// Observe feeds the outcome of a live request to passive outlier detection.
+// After the configured number of consecutive failures the backend is ejected
+// for a while; Observe then returns true.
+func (u *Upstream) Observe(b *Backend, failed bool) bool {
+	p := u.HealthCheck.Passive
+	if p.ConsecutiveFailures <= 0 {
+		return false
+	}
+	if !failed {
+		b.failures.Store(0)
+		return false
+	}
+	if b.failures.Add(1) < int64(p.ConsecutiveFailures) {
+		return false
+	}
+	b.failures.Store(0)
+	eject := defaultEjectDuration
+	if p.EjectSeconds > 0 {
+		eject = time.Duration(p.EjectSeconds) * time.Second
+	}
+	b.ejectedUntil.Store(time.Now().Add(eject).UnixNano())
+	return true
+}
+
+// HealthChecker runs the active probes of every upstream that has them
+// configured, and publishes the health of every backend.
+type HealthChecker struct {
+	upstreams *Set
+	logger    *slog.Logger
+	metrics   *metrics.Metrics
+
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// NewHealthChecker creates a health checker for the upstreams. Nothing runs
+// until Start is called.
+func NewHealthChecker(upstreams *Set, logger *slog.Logger, mets *metrics.Metrics) *HealthChecker {
+	return &HealthChecker{
+		upstreams: upstreams,
+		logger:    logger.With("component", "health_checker"),
+		metrics:   mets,
+	}
+}
+
+// Start launches one probe loop per backend with active checks, and the
+// reporting loop.
+func (c *HealthChecker) Start() {
+	ctx, stop := context.WithCancel(context.Background())
+	c.stop = stop
+	for _, up := range c.upstreams.All() {
+		if up.HealthCheck.Active.Path == "" {
+			continue
+		}
+		for _, b := range up.Backends {
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+				c.probeLoop(ctx, up, b)
+			}()
+		}
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.reportLoop(ctx)
+	}()
+}
+
+// Stop ends all loops and waits for them.
+func (c *HealthChecker) Stop() {
+	if c.stop != nil {
+		c.stop()
+		c.wg.Wait()
+	}
+}
+
+// probeLoop probes one backend until ctx is done, moving it out of rotation
+// after UnhealthyThreshold failures in a row and back after HealthyThreshold
+// successes.
+func (c *HealthChecker) probeLoop(ctx context.Context, up *Upstream, b *Backend) {
+	a := up.HealthCheck.Active
+	interval := durationOr(a.IntervalMS, defaultProbeInterval)
+	healthyAfter := valueOr(a.HealthyThreshold, defaultHealthyThreshold)
+	unhealthyAfter := valueOr(a.UnhealthyThreshold, defaultUnhealthyThreshold)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	successes, failures := 0, 0
+	for {
+		err := c.probe(ctx, up, b)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			successes, failures = successes+1, 0
+			c.metrics.HealthChecks.WithLabelValues(up.Name, b.Name(), "success").Inc()
+			if successes >= healthyAfter && b.failing.Swap(false) {
+				c.logger.Info("backend passed its health checks", "upstream", up.Name, "backend", b.Name())
+			}
+		} else {
+			successes, failures = 0, failures+1
+			c.metrics.HealthChecks.WithLabelValues(up.Name, b.Name(), "failure").Inc()
+			c.logger.Debug("health check failed", "upstream", up.Name, "backend", b.Name(), "error", err)
+			if failures >= unhealthyAfter && !b.failing.Swap(true) {
+				c.logger.Warn("backend failed its health checks", "upstream", up.Name, "backend", b.Name(), "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe sends one health check request to the backend.
+func (c *HealthChecker) probe(ctx context.Context, up *Upstream, b *Backend) error {
+	a := up.HealthCheck.Active
+	ctx, cancel := context.WithTimeout(ctx, durationOr(a.TimeoutMS, defaultProbeTimeout))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.Path, nil)
+	if err != nil {
+		return err
+	}
+	b.director(req)
+	// Probes carry the static credentials but no OAuth2 token, so that a
+	// failing token endpoint doesn't take every backend out of rotation.
+	up.Credentials.Apply(req)
+	resp, err := b.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	ok := resp.StatusCode >= 200 && resp.StatusCode < 400
+	if len(a.ExpectedStatus) > 0 {
+		ok = slices.Contains(a.ExpectedStatus, resp.StatusCode)
+	}
+	if !ok {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// reportLoop publishes every backend's health to Prometheus and logs when a
+// backend enters or leaves rotation for any reason.
+func (c *HealthChecker) reportLoop(ctx context.Context) {
+	ticker := time.NewTicker(healthReportInterval)
+	defer ticker.Stop()
+	last := make(map[*Backend]bool)
+	for {
+		for _, up := range c.upstreams.All() {
+			for _, b := range up.Backends {
+				healthy := b.Healthy()
+				if was, seen := last[b]; seen && was != healthy {
+					c.logger.Info("backend rotation changed", "upstream", up.Name, "backend", b.Name(), "healthy", healthy,
+						"disabled", b.Disabled(), "failing", b.Failing(), "ejected", b.Ejected())
+				}
+				last[b] = healthy
+				gauge := 0.0
+				if healthy {
+					gauge = 1
+				}
+				c.metrics.BackendHealthy.WithLabelValues(up.Name, b.Name()).Set(gauge)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// durationOr converts milliseconds to a duration, or returns def if ms is
+// not positive.
+func durationOr(ms int, def time.Duration) time.Duration {
+	if ms <= 0 {
+		return def
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// valueOr returns n, or def if n is not positive.
+func valueOr(n, def int) int {
+	if n <= 0 {
+		return def
+	}
+	return n
+}
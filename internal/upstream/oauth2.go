@@ -0,0 +1,261 @@
+// File: internal/upstream/oauth2.go
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-caching-proxy/internal/config"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTokenUnavailable wraps the errors of obtaining an OAuth2 access token.
+// They say nothing about the upstream's backends.
+var ErrTokenUnavailable = errors.New("oauth2 token unavailable")
+
+const (
+	defaultRefreshBefore = time.Minute
+	defaultTokenTimeout  = 10 * time.Second
+	// defaultTokenLifetime is assumed when the token endpoint doesn't say
+	// when a token expires.
+	defaultTokenLifetime = 5 * time.Minute
+)
+
+// TokenSource obtains OAuth2 access tokens with the client credentials
+// grant and caches them until shortly before they expire. Concurrent
+// requests needing a new token share a single token request.
+type TokenSource struct {
+	tokenURL      string
+	clientID      string
+	clientSecret  *secret
+	form          url.Values // Grant type, scopes and extra parameters
+	basicAuth     bool
+	refreshBefore time.Duration
+	timeout       time.Duration
+	client        *http.Client
+
+	mu        sync.Mutex
+	token     string
+	refreshAt time.Time
+	fetching  chan struct{} // Closed when the token request in flight ends
+	err       error         // Outcome of the last token request
+}
+
+// NewTokenSource creates the token source for an OAuth2 configuration. No
+// token is requested until one is needed.
+func NewTokenSource(c config.OAuth2) (*TokenSource, error) {
+	u, err := url.Parse(c.TokenURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("oauth2 token_url %q must be an absolute URL", c.TokenURL)
+	}
+	if c.ClientID == "" {
+		return nil, errors.New("oauth2 client_id is required")
+	}
+	clientSecret, err := newSecret(c.ClientSecret, "")
+	if err != nil {
+		return nil, fmt.Errorf("oauth2 client_secret: %w", err)
+	}
+	s := &TokenSource{
+		tokenURL:      c.TokenURL,
+		clientID:      c.ClientID,
+		clientSecret:  clientSecret,
+		form:          url.Values{"grant_type": {"client_credentials"}},
+		refreshBefore: defaultRefreshBefore,
+		timeout:       durationOr(c.TimeoutMS, defaultTokenTimeout),
+		client:        &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+	}
+	switch c.ClientAuth {
+	case "", "basic":
+		s.basicAuth = true
+	case "body":
+	default:
+		return nil, fmt.Errorf("oauth2 client_auth %q must be \"basic\" or \"body\"", c.ClientAuth)
+	}
+	if c.RefreshBeforeSeconds > 0 {
+		s.refreshBefore = time.Duration(c.RefreshBeforeSeconds) * time.Second
+	}
+	if len(c.Scopes) > 0 {
+		s.form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	for k, v := range c.Params {
+		s.form.Set(k, v)
+	}
+	return s, nil
+}
+
+// Token returns a valid access token, requesting a new one if the cached
+// one is missing or about to expire.
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+	return s.get(ctx, "")
+}
+
+// Refresh returns a new access token after the origin rejected the token
+// rejected. If another request already replaced it, that token is returned
+// without asking the token endpoint again.
+func (s *TokenSource) Refresh(ctx context.Context, rejected string) (string, error) {
+	return s.get(ctx, rejected)
+}
+
+// current returns the cached token, valid or not, without requesting one.
+func (s *TokenSource) current() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// get returns the cached token unless it is due for a refresh or is
+// rejected; otherwise it requests a new one, or waits for the request
+// already in flight.
+func (s *TokenSource) get(ctx context.Context, rejected string) (string, error) {
+	s.mu.Lock()
+	for {
+		if s.token != "" && s.token != rejected && time.Now().Before(s.refreshAt) {
+			token := s.token
+			s.mu.Unlock()
+			return token, nil
+		}
+		if s.fetching == nil {
+			break
+		}
+		fetching := s.fetching
+		s.mu.Unlock()
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		s.mu.Lock()
+		if s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			return "", err
+		}
+		// A token the origin just rejected may come back from the token
+		// endpoint unchanged; accept it rather than asking again.
+		rejected = ""
+	}
+	fetching := make(chan struct{})
+	s.fetching = fetching
+	s.mu.Unlock()
+
+	// The token is shared, so it is requested independently of the
+	// request that happened to need it first.
+	token, lifetime, err := s.fetch()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetching, s.err = nil, err
+	close(fetching)
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	s.refreshAt = time.Now().Add(max(lifetime-s.refreshBefore, lifetime/2))
+	return token, nil
+}
+
+// tokenResponse is the token endpoint's answer (RFC 6749, section 5).
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// fetch requests a new token from the token endpoint.
+func (s *TokenSource) fetch() (string, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	form := url.Values{}
+	for k, v := range s.form {
+		form[k] = v
+	}
+	if !s.basicAuth {
+		form.Set("client_id", s.clientID)
+		form.Set("client_secret", s.clientSecret.Get())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if s.basicAuth {
+		// RFC 6749 wants both form-encoded before Basic encoding.
+		req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret.Get()))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+	defer resp.Body.Close()
+	var tr tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tr); err != nil && resp.StatusCode == http.StatusOK {
+		return "", 0, fmt.Errorf("%w: decoding token response: %v", ErrTokenUnavailable, err)
+	}
+	switch {
+	case resp.StatusCode != http.StatusOK:
+		return "", 0, fmt.Errorf("%w: token endpoint answered %d %s %s", ErrTokenUnavailable, resp.StatusCode, tr.Error, tr.ErrorDescription)
+	case tr.AccessToken == "":
+		return "", 0, fmt.Errorf("%w: token response has no access_token", ErrTokenUnavailable)
+	case tr.TokenType != "" && !strings.EqualFold(tr.TokenType, "bearer"):
+		return "", 0, fmt.Errorf("%w: unsupported token type %q", ErrTokenUnavailable, tr.TokenType)
+	}
+	lifetime := defaultTokenLifetime
+	if tr.ExpiresIn > 0 {
+		lifetime = time.Duration(tr.ExpiresIn) * time.Second
+	}
+	return tr.AccessToken, lifetime, nil
+}
+
+// Send sends a request, already directed by Direct, to one of the
+// upstream's backends, with an access token if the upstream uses OAuth2. A
+// 401 means the token was revoked or expired early: the token is refreshed
+// and the request sent once more, if its body can be replayed.
+func (u *Upstream) Send(b *Backend, req *http.Request) (*http.Response, error) {
+	tokens := u.Credentials.Tokens()
+	if tokens == nil {
+		return b.RoundTrip(req)
+	}
+	token, err := tokens.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.RoundTrip(withToken(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	fresh, err := tokens.Refresh(req.Context(), token)
+	if err != nil {
+		// The origin's 401 says more to the client than a 502 would.
+		return resp, nil
+	}
+	retry := withToken(req, fresh)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return b.RoundTrip(retry)
+}
+
+// withToken returns a copy of req carrying the access token.
+func withToken(req *http.Request, token string) *http.Request {
+	out := req.Clone(req.Context())
+	out.Header.Set("Authorization", "Bearer "+token)
+	return out
+}
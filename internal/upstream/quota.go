@@ -0,0 +1,199 @@
+// File: internal/upstream/quota.go
+package upstream
+
+import (
+	"fmt"
+	"go-caching-proxy/internal/config"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRemainingHeader = "X-RateLimit-Remaining"
+	defaultResetHeader     = "X-RateLimit-Reset"
+	defaultQueueTimeout    = 5 * time.Second
+	// defaultQuotaWindow is how long a reported quota is assumed to hold
+	// when the origin doesn't say when it resets, or answers 429 without a
+	// Retry-After.
+	defaultQuotaWindow = time.Minute
+	// minUnixReset tells Unix times from seconds from now in reset headers.
+	minUnixReset = 1_000_000_000
+)
+
+// Quota tracks an upstream's rate limit from its response headers. While
+// little of it is left, the upstream is protected: requests are served from
+// stale entries, paced, or rejected instead of spending what remains.
+type Quota struct {
+	minRemaining    int
+	remainingHeader string
+	resetHeader     string
+	queue           bool
+	queueTimeout    time.Duration
+
+	mu           sync.Mutex
+	known        bool // The origin reported its quota
+	remaining    int
+	resetAt      time.Time
+	blockedUntil time.Time // After a 429, per its Retry-After
+	nextSlot     time.Time // When the next paced request may go
+}
+
+// NewQuota creates the quota tracker of an upstream.
+func NewQuota(c config.Quota) (*Quota, error) {
+	q := &Quota{
+		minRemaining:    c.MinRemaining,
+		remainingHeader: c.RemainingHeader,
+		resetHeader:     c.ResetHeader,
+		queueTimeout:    durationOr(c.QueueTimeoutMS, defaultQueueTimeout),
+	}
+	if q.remainingHeader == "" {
+		q.remainingHeader = defaultRemainingHeader
+	}
+	if q.resetHeader == "" {
+		q.resetHeader = defaultResetHeader
+	}
+	switch c.OnMiss {
+	case "", "reject":
+	case "queue":
+		q.queue = true
+	default:
+		return nil, fmt.Errorf("quota on_miss %q must be \"reject\" or \"queue\"", c.OnMiss)
+	}
+	return q, nil
+}
+
+// Enabled reports whether the quota is tracked.
+func (q *Quota) Enabled() bool {
+	return q.minRemaining > 0
+}
+
+// Observe updates the quota from an origin response.
+func (q *Quota) Observe(resp *http.Response) {
+	if !q.Enabled() {
+		return
+	}
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if n, err := strconv.Atoi(strings.TrimSpace(resp.Header.Get(q.remainingHeader))); err == nil {
+		q.known = true
+		q.remaining = n
+		q.resetAt = now.Add(defaultQuotaWindow)
+	}
+	if reset, ok := parseReset(resp.Header.Get(q.resetHeader), now); ok {
+		q.resetAt = reset
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		until, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+		switch {
+		case ok:
+		case resp.StatusCode != http.StatusTooManyRequests:
+			return // An ordinary outage, not a rate limit
+		case q.known && q.resetAt.After(now):
+			until = q.resetAt
+		default:
+			until = now.Add(defaultQuotaWindow)
+		}
+		if until.After(q.blockedUntil) {
+			q.blockedUntil = until
+		}
+	}
+}
+
+// Remaining returns the quota the origin last reported, if it did.
+func (q *Quota) Remaining() (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.remaining, q.known
+}
+
+// Low reports whether the upstream's quota is being protected.
+func (q *Quota) Low() bool {
+	if !q.Enabled() {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.low(time.Now())
+}
+
+// low reports whether the quota is low. Must be called with the lock held.
+func (q *Quota) low(now time.Time) bool {
+	return now.Before(q.blockedUntil) || (q.known && q.remaining < q.minRemaining && now.Before(q.resetAt))
+}
+
+// Reserve claims the right to send a request to the origin. It returns how
+// long the request must wait before going, or false and how long until it
+// could go if it may not be sent: when the quota is low and misses are
+// rejected, or when its turn in the queue is further off than the queue
+// timeout.
+func (q *Quota) Reserve() (time.Duration, bool) {
+	if !q.Enabled() {
+		return 0, true
+	}
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.low(now) {
+		return 0, true
+	}
+
+	var slot time.Time
+	switch {
+	case now.Before(q.blockedUntil) || q.remaining <= 0:
+		// Nothing may be sent before the limit resets.
+		slot = q.blockedUntil
+		if q.known && q.remaining <= 0 && q.resetAt.After(slot) {
+			slot = q.resetAt
+		}
+	case !q.queue:
+		slot = q.resetAt
+	default:
+		// Spread what is left evenly over the time until the reset.
+		slot = now
+		if q.nextSlot.After(slot) {
+			slot = q.nextSlot
+		}
+	}
+	wait := slot.Sub(now)
+	if !q.queue || wait > q.queueTimeout {
+		return wait, false
+	}
+	if q.remaining > 0 && !now.Before(q.blockedUntil) {
+		q.nextSlot = slot.Add(q.resetAt.Sub(now) / time.Duration(q.remaining))
+		q.remaining--
+	}
+	return wait, true
+}
+
+// parseReset parses a rate-limit reset header holding either a Unix time or
+// a number of seconds from now.
+func parseReset(v string, now time.Time) (time.Time, bool) {
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || n < 0 {
+		return time.Time{}, false
+	}
+	if n >= minUnixReset {
+		return time.Unix(n, 0), true
+	}
+	return now.Add(time.Duration(n) * time.Second), true
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Time, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, false
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return now.Add(time.Duration(n) * time.Second), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,200 @@
+// File: internal/upstream/retry.go
+package upstream
+
+import (
+	"go-caching-proxy/internal/config"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBackoff          = 25 * time.Millisecond
+	defaultMaxBackoff       = time.Second
+	defaultBudgetRatio      = 0.2
+	defaultBudgetMinRetries = 10
+	retryBudgetWindow       = 10 * time.Second
+
+	// latencySamples is how many recent response times hedging computes
+	// its percentile over, and minLatencySamples how many it needs first.
+	latencySamples    = 512
+	minLatencySamples = 20
+)
+
+var defaultRetryStatus = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// RetryPolicy decides whether and when a failed request is retried. Retries
+// are limited per request by MaxRetries and across the upstream by a budget
+// of a fraction of its recent requests, so that retries can't multiply the
+// load on an origin that is already struggling.
+type RetryPolicy struct {
+	maxRetries int
+	onStatus   []int
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	mu          sync.Mutex
+	ratio       float64
+	minRetries  int
+	windowStart time.Time
+	requests    int
+	retries     int
+}
+
+// NewRetryPolicy creates the retry policy for an upstream.
+func NewRetryPolicy(c config.Retry) *RetryPolicy {
+	p := &RetryPolicy{
+		maxRetries: c.MaxRetries,
+		onStatus:   c.OnStatus,
+		backoff:    durationOr(c.BackoffMS, defaultBackoff),
+		maxBackoff: durationOr(c.MaxBackoffMS, defaultMaxBackoff),
+		ratio:      c.BudgetRatio,
+		minRetries: valueOr(c.BudgetMinRetries, defaultBudgetMinRetries),
+	}
+	if len(p.onStatus) == 0 {
+		p.onStatus = defaultRetryStatus
+	}
+	if p.ratio <= 0 {
+		p.ratio = defaultBudgetRatio
+	}
+	return p
+}
+
+// MaxRetries returns how many times one request may be retried.
+func (p *RetryPolicy) MaxRetries() int {
+	return p.maxRetries
+}
+
+// Retryable reports whether a request may be sent again: its method must be
+// idempotent and its body, if any, replayable.
+func (p *RetryPolicy) Retryable(req *http.Request) bool {
+	if p.maxRetries <= 0 {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// RetryStatus reports whether a response with the status is worth retrying.
+func (p *RetryPolicy) RetryStatus(status int) bool {
+	return slices.Contains(p.onStatus, status)
+}
+
+// Backoff returns how long to wait before the given retry (1 for the first):
+// a random time up to the exponentially growing cap ("full jitter"), so that
+// clients that failed together don't retry together.
+func (p *RetryPolicy) Backoff(retry int) time.Duration {
+	limit := p.maxBackoff
+	if retry < 32 {
+		limit = min(p.backoff<<(retry-1), p.maxBackoff)
+	}
+	return rand.N(limit + 1)
+}
+
+// Request counts a request towards the retry budget.
+func (p *RetryPolicy) Request() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.roll()
+	p.requests++
+}
+
+// AllowRetry takes a retry from the budget, reporting false when it is
+// exhausted.
+func (p *RetryPolicy) AllowRetry() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.roll()
+	if p.retries >= int(p.ratio*float64(p.requests))+p.minRetries {
+		return false
+	}
+	p.retries++
+	return true
+}
+
+// roll starts a new budget window when the current one is over. Must be
+// called with the lock held.
+func (p *RetryPolicy) roll() {
+	if now := time.Now(); now.Sub(p.windowStart) >= retryBudgetWindow {
+		p.windowStart = now
+		p.requests, p.retries = 0, 0
+	}
+}
+
+// HedgePolicy decides when to send a second, hedged request, from the
+// response times of recent requests.
+type HedgePolicy struct {
+	percentile float64
+	minDelay   time.Duration
+
+	mu        sync.Mutex
+	samples   []time.Duration // Ring buffer of recent response times
+	next      int
+	threshold time.Duration
+	stale     int // Samples added since threshold was computed
+}
+
+// NewHedgePolicy creates the hedging policy for an upstream.
+func NewHedgePolicy(c config.Hedge) *HedgePolicy {
+	return &HedgePolicy{
+		percentile: c.Percentile,
+		minDelay:   time.Duration(c.MinDelayMS) * time.Millisecond,
+		samples:    make([]time.Duration, 0, latencySamples),
+	}
+}
+
+// Hedgeable reports whether a request may be hedged: hedging must be on and
+// the request idempotent and without a body, since it is sent twice at once.
+func (p *HedgePolicy) Hedgeable(req *http.Request) bool {
+	if p.percentile <= 0 {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// Observe records how long a backend took to answer.
+func (p *HedgePolicy) Observe(d time.Duration) {
+	if p.percentile <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.samples) < latencySamples {
+		p.samples = append(p.samples, d)
+	} else {
+		p.samples[p.next] = d
+		p.next = (p.next + 1) % latencySamples
+	}
+	p.stale++
+}
+
+// Delay returns how long to wait for a response before hedging, or false
+// while too few response times are known.
+func (p *HedgePolicy) Delay() (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.samples) < minLatencySamples {
+		return 0, false
+	}
+	// Sorting the window on every request would be wasteful; the
+	// percentile barely moves between a few samples.
+	if p.stale >= minLatencySamples {
+		sorted := slices.Clone(p.samples)
+		slices.Sort(sorted)
+		i := min(int(float64(len(sorted))*p.percentile/100), len(sorted)-1)
+		p.threshold = sorted[i]
+		p.stale = 0
+	}
+	return max(p.threshold, p.minDelay), true
+}
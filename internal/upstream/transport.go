@@ -0,0 +1,94 @@
+// File: internal/upstream/transport.go
+package upstream
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"go-caching-proxy/internal/config"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// newTransport creates a connection pool for one upstream, starting from
+// the settings of http.DefaultTransport.
+func newTransport(tc config.Transport) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if tc.DialTimeoutMS > 0 {
+		dialer := &net.Dialer{
+			Timeout:   time.Duration(tc.DialTimeoutMS) * time.Millisecond,
+			KeepAlive: 30 * time.Second,
+		}
+		t.DialContext = dialer.DialContext
+	}
+	setDuration(&t.TLSHandshakeTimeout, tc.TLSHandshakeTimeoutMS)
+	setDuration(&t.ResponseHeaderTimeout, tc.ResponseHeaderTimeoutMS)
+	setDuration(&t.IdleConnTimeout, tc.IdleConnTimeoutMS)
+	if tc.MaxIdleConns > 0 {
+		t.MaxIdleConns = tc.MaxIdleConns
+	}
+	if tc.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = tc.MaxIdleConnsPerHost
+	}
+	if tc.MaxConnsPerHost > 0 {
+		t.MaxConnsPerHost = tc.MaxConnsPerHost
+	}
+
+	tlsConfig, err := newTLSConfig(tc.TLS)
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = tlsConfig
+
+	if tc.DisableHTTP2 {
+		// A non-nil, empty TLSNextProto is how net/http is told not to
+		// negotiate HTTP/2.
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t, nil
+}
+
+// newTLSConfig builds the client TLS settings, or returns nil to use Go's
+// defaults when none are configured.
+func newTLSConfig(c config.TLS) (*tls.Config, error) {
+	if c == (config.TLS{}) {
+		return nil, nil
+	}
+	tc := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %q", c.CAFile)
+		}
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("tls cert_file and key_file must be set together")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// setDuration sets *d from milliseconds if ms is positive.
+func setDuration(d *time.Duration, ms int) {
+	if ms > 0 {
+		*d = time.Duration(ms) * time.Millisecond
+	}
+}
@@ -0,0 +1,293 @@
+// File: internal/upstream/upstream.go
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"go-caching-proxy/internal/config"
+	"go-caching-proxy/internal/key"
+	"go-caching-proxy/internal/route"
+	"net/http"
+	"strings"
+)
+
+// DefaultName is the name of the upstream built from proxy.target, which
+// receives every request no named upstream claims.
+const DefaultName = "default"
+
+// Upstream is one named origin the proxy can send requests to. Requests are
+// assigned to it by Host header or path prefix; each upstream keeps its
+// entries in its own cache namespace and has its own connection pool, shared
+// by its backends.
+type Upstream struct {
+	Name string
+
+	// Backends are the origin servers; Balancer picks one per request
+	// according to the Balance strategy.
+	Backends []*Backend
+	Balance  string
+	Balancer Balancer
+
+	// Hosts are the Host headers (exact, or "*.example.com") routed here.
+	Hosts []string
+	// PathPrefix routes requests under it here. With StripPrefix, the
+	// prefix is removed before the request is sent to the origin.
+	PathPrefix  string
+	StripPrefix bool
+
+	// PreserveHost forwards the client's Host header instead of the
+	// target's. The default upstream preserves it, as the proxy always has;
+	// named upstreams usually front virtual hosts that need their own name.
+	PreserveHost bool
+
+	// Encoder namespaces this upstream's cache keys and tags.
+	Encoder *key.Encoder
+
+	// Transport carries requests to the origin.
+	Transport http.RoundTripper
+
+	// HealthCheck configures the active probes and passive outlier
+	// detection that take backends out of rotation.
+	HealthCheck config.HealthCheck
+
+	// Retry and Hedge decide when failed or slow requests are sent again.
+	Retry *RetryPolicy
+	Hedge *HedgePolicy
+
+	// Breaker stops requests to the upstream while it is failing.
+	Breaker *Breaker
+
+	// Credentials are added to every request to the origin; nil adds
+	// none.
+	Credentials *Credentials
+
+	// Quota tracks the origin's rate limit.
+	Quota *Quota
+}
+
+// New creates an upstream balancing over backends, with its own transport.
+func New(name string, backends []config.Backend, balance string, encoder *key.Encoder, tc config.Transport) (*Upstream, error) {
+	transport, err := newTransport(tc)
+	if err != nil {
+		return nil, fmt.Errorf("upstream %q: %w", name, err)
+	}
+	up := &Upstream{
+		Name:      name,
+		Balance:   balance,
+		Encoder:   encoder,
+		Transport: transport,
+		Retry:     NewRetryPolicy(config.Retry{}),
+		Hedge:     NewHedgePolicy(config.Hedge{}),
+		Breaker:   NewBreaker(config.CircuitBreaker{}),
+		Quota:     &Quota{},
+	}
+	seen := make(map[string]bool)
+	for _, bc := range backends {
+		b, err := newBackend(bc.URL, bc.Weight, up.Transport)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %q: %w", name, err)
+		}
+		if seen[b.Name()] {
+			return nil, fmt.Errorf("upstream %q: duplicate backend %q", name, b.Name())
+		}
+		seen[b.Name()] = true
+		up.Backends = append(up.Backends, b)
+	}
+	balancer, err := NewBalancer(balance, up.Backends)
+	if err != nil {
+		return nil, fmt.Errorf("upstream %q: %w", name, err)
+	}
+	up.Balancer = balancer
+	return up, nil
+}
+
+// Matches reports whether the request is routed to this upstream.
+func (u *Upstream) Matches(r *http.Request) bool {
+	for _, h := range u.Hosts {
+		if route.MatchHost(h, r.Host) {
+			return true
+		}
+	}
+	return u.PathPrefix != "" && hasPathPrefix(r.URL.Path, u.PathPrefix)
+}
+
+// Direct rewrites an incoming request into a request to the origin, as the
+// Director of an httputil.ReverseProxy. The backend's scheme, host and base
+// path are applied by Backend.RoundTrip once one has been picked.
+func (u *Upstream) Direct(req *http.Request) {
+	if u.StripPrefix && u.PathPrefix != "" {
+		req.URL.Path = stripPathPrefix(req.URL.Path, u.PathPrefix)
+		if req.URL.RawPath != "" {
+			req.URL.RawPath = stripPathPrefix(req.URL.RawPath, u.PathPrefix)
+		}
+	}
+	if !u.PreserveHost {
+		req.Host = "" // Use the backend's host from req.URL
+	}
+	// The cache key was built from the client's request by now, so the
+	// credentials never become part of it.
+	u.Credentials.Apply(req)
+}
+
+// Pick returns the backend for a request with the given balancing key.
+func (u *Upstream) Pick(key string) *Backend {
+	return u.Balancer.Pick(key)
+}
+
+// Backend returns the backend with the given name.
+func (u *Upstream) Backend(name string) (*Backend, bool) {
+	for _, b := range u.Backends {
+		if b.Name() == name {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// IsBackendHost reports whether host is the host of one of the backends,
+// e.g. in a Location header the origin sent.
+func (u *Upstream) IsBackendHost(host string) bool {
+	for _, b := range u.Backends {
+		if strings.EqualFold(b.URL.Host, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExternalPath maps a path on the origin back to the path clients use for
+// it, re-adding a stripped prefix.
+func (u *Upstream) ExternalPath(p string) string {
+	if u.StripPrefix && u.PathPrefix != "" {
+		return strings.TrimSuffix(u.PathPrefix, "/") + p
+	}
+	return p
+}
+
+// Set is the ordered list of upstreams plus the default one.
+type Set struct {
+	upstreams []*Upstream
+	def       *Upstream
+}
+
+// NewSet builds the named upstreams from the configuration, in order, and
+// the default upstream from def (proxy: in the configuration) if it has a
+// target or backends. The default upstream takes every setting a named one
+// does except those that route requests to it, and always preserves the
+// client's Host header. Each upstream's namespace replaces the one of
+// encoder; they all share its generation.
+func NewSet(cfgs []config.Upstream, def config.Upstream, encoder *key.Encoder) (*Set, error) {
+	s := &Set{}
+	seen := make(map[string]bool)
+	for _, c := range cfgs {
+		if c.Name == "" || c.Name == DefaultName || seen[c.Name] {
+			return nil, fmt.Errorf("upstream name %q is empty, reserved or duplicated", c.Name)
+		}
+		seen[c.Name] = true
+		if len(c.Hosts) == 0 && c.PathPrefix == "" {
+			return nil, fmt.Errorf("upstream %q: needs hosts or a path_prefix to route requests to it", c.Name)
+		}
+		u, err := fromConfig(c, encoder)
+		if err != nil {
+			return nil, err
+		}
+		s.upstreams = append(s.upstreams, u)
+	}
+	if def.Target != "" || len(def.Backends) > 0 {
+		if def.Name != "" || len(def.Hosts) > 0 || def.PathPrefix != "" || def.StripPrefix {
+			return nil, errors.New("proxy: name, hosts, path_prefix and strip_prefix only apply to named upstreams")
+		}
+		def.Name = DefaultName
+		u, err := fromConfig(def, encoder)
+		if err != nil {
+			return nil, err
+		}
+		u.PreserveHost = true
+		s.def = u
+	}
+	if len(s.upstreams) == 0 && s.def == nil {
+		return nil, errors.New("no upstreams: set proxy.target or upstreams")
+	}
+	return s, nil
+}
+
+// fromConfig builds one upstream from its configuration.
+func fromConfig(c config.Upstream, encoder *key.Encoder) (*Upstream, error) {
+	backends := c.Backends
+	if c.Target != "" {
+		backends = append([]config.Backend{{URL: c.Target}}, backends...)
+	}
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("upstream %q: needs a target or backends", c.Name)
+	}
+	u, err := New(c.Name, backends, c.Balance, encoder.WithNamespace(c.Namespace), c.Transport)
+	if err != nil {
+		return nil, err
+	}
+	u.Hosts = c.Hosts
+	u.PathPrefix = c.PathPrefix
+	u.StripPrefix = c.StripPrefix
+	u.PreserveHost = c.PreserveHost
+	u.HealthCheck = c.HealthCheck
+	u.Retry = NewRetryPolicy(c.Retry)
+	u.Hedge = NewHedgePolicy(c.Hedge)
+	u.Breaker = NewBreaker(c.CircuitBreaker)
+	if u.Credentials, err = NewCredentials(c.Credentials); err != nil {
+		return nil, fmt.Errorf("upstream %q: %w", c.Name, err)
+	}
+	if u.Quota, err = NewQuota(c.Quota); err != nil {
+		return nil, fmt.Errorf("upstream %q: %w", c.Name, err)
+	}
+	return u, nil
+}
+
+// Single returns a Set with only a default upstream.
+func Single(target string, encoder *key.Encoder) (*Set, error) {
+	return NewSet(nil, config.Upstream{Target: target}, encoder)
+}
+
+// Match returns the first upstream the request is routed to, the default
+// upstream if none claims it, or nil if there is no default.
+func (s *Set) Match(r *http.Request) *Upstream {
+	for _, u := range s.upstreams {
+		if u.Matches(r) {
+			return u
+		}
+	}
+	return s.def
+}
+
+// Get returns the upstream with the given name.
+func (s *Set) Get(name string) (*Upstream, bool) {
+	for _, u := range s.All() {
+		if u.Name == name {
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+// All returns every upstream, the default one last.
+func (s *Set) All() []*Upstream {
+	all := append([]*Upstream(nil), s.upstreams...)
+	if s.def != nil {
+		all = append(all, s.def)
+	}
+	return all
+}
+
+// hasPathPrefix reports whether p is prefix or lies below it, so that
+// "/github" matches "/github" and "/github/x" but not "/githubx".
+func hasPathPrefix(p, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// stripPathPrefix removes prefix from p, keeping the result rooted.
+func stripPathPrefix(p, prefix string) string {
+	p = strings.TrimPrefix(p, strings.TrimSuffix(prefix, "/"))
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
@@ -1,5 +0,0 @@
-// File: internal/admin/admin.go
-package admin
-
-// This file is a placeholder for admin-related HTTP handlers,
-// such as /healthz, /stats, or /cache/flush.
\ No newline at end of file
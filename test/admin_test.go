@@ -0,0 +1,194 @@
+// File: test/admin_test.go
+package test
+
+import (
+	"encoding/json"
+	"go-caching-proxy/internal/admin"
+	"go-caching-proxy/internal/cache"
+	"go-caching-proxy/internal/key"
+	"go-caching-proxy/internal/middleware"
+	"go-caching-proxy/internal/proxy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAdminAPI walks through listing, inspecting, deleting, purging and
+// flushing entries through the admin API.
+func TestAdminAPI(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Surrogate-Key", "page")
+		w.Write([]byte("hello"))
+	}))
+	defer origin.Close()
+
+	c := cache.NewLRUCache(100)
+	encoder, _ := key.NewEncoder("gocache", 1, key.HashNone)
+	h, err := proxy.NewHandler(origin.URL, c, time.Minute, testLogger(), testMetrics(), proxy.WithKeyEncoder(encoder))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	api := admin.NewAPI(c, h, testLogger())
+	adminSrv := httptest.NewServer(middleware.Auth("secret", api.Handler()))
+	defer adminSrv.Close()
+
+	call := func(method, path string, out any) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, adminSrv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+
+	for _, p := range []string{"/a/1", "/a/2", "/a/3", "/b/1"} {
+		get(t, srv.URL+p, nil)
+	}
+	get(t, srv.URL+"/a/1", nil) // One hit
+
+	// Unauthenticated requests are refused.
+	if resp, err := http.Get(adminSrv.URL + "/api/keys"); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %v (err %v)", resp.StatusCode, err)
+	}
+
+	// Pagination.
+	var page struct {
+		Keys  []string `json:"keys"`
+		Next  string   `json:"next"`
+		Total int      `json:"total"`
+	}
+	if code := call("GET", "/api/keys?limit=3", &page); code != http.StatusOK || page.Total != 4 || len(page.Keys) != 3 || page.Next == "" {
+		t.Fatalf("unexpected first page (%d): %+v", code, page)
+	}
+	first := page.Keys[0]
+	after := page.Next
+	page.Next = ""
+	call("GET", "/api/keys?limit=3&after="+url.QueryEscape(after), &page)
+	if len(page.Keys) != 1 || page.Next != "" {
+		t.Fatalf("unexpected second page: %+v", page)
+	}
+
+	// Entry metadata.
+	var info struct {
+		StatusCode int      `json:"status_code"`
+		Size       int      `json:"size"`
+		AgeSeconds *float64 `json:"age_seconds"`
+		TTLSeconds float64  `json:"ttl_seconds"`
+		Hits       *int64   `json:"hits"`
+		Tags       []string `json:"tags"`
+	}
+	if code := call("GET", "/api/entry?key="+url.QueryEscape(first), &info); code != http.StatusOK {
+		t.Fatalf("expected entry %q to exist, got %d", first, code)
+	}
+	if info.StatusCode != 200 || info.Size != 5 || info.AgeSeconds == nil || info.TTLSeconds <= 0 ||
+		info.Hits == nil || *info.Hits != 1 {
+		t.Errorf("unexpected entry metadata: %+v", info)
+	}
+
+	// Delete one key, purge by prefix, then flush the rest.
+	call("DELETE", "/api/entry?key="+url.QueryEscape(first), nil)
+	if code := call("GET", "/api/entry?key="+url.QueryEscape(first), nil); code != http.StatusNotFound {
+		t.Errorf("expected deleted entry to be gone, got %d", code)
+	}
+	var purged struct {
+		Purged int `json:"purged"`
+	}
+	call("POST", "/api/purge?regex=/a/", &purged)
+	if purged.Purged != 2 {
+		t.Errorf("expected 2 entries purged by regex, got %d", purged.Purged)
+	}
+	call("POST", "/api/flush", &purged)
+	if purged.Purged != 1 {
+		t.Errorf("expected flush to remove the last entry, got %d", purged.Purged)
+	}
+	call("GET", "/api/keys", &page)
+	if page.Total != 0 {
+		t.Errorf("expected an empty cache after flush, got %v", page.Keys)
+	}
+}
+
+// TestExplain verifies that the explain endpoint reports the key and cache
+// status of a request and the reasons its response would not be cached.
+func TestExplain(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+			w.Header().Set("Set-Cookie", "session=1")
+		case "/vary":
+			w.Header().Set("Vary", "Accept-Language, Accept-Encoding")
+		case "/big":
+			w.Write([]byte(strings.Repeat("x", 100)))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	c := cache.NewLRUCache(10)
+	encoder, _ := key.NewEncoder("gocache", 1, key.HashNone)
+	h, err := proxy.NewHandler(origin.URL, c, time.Minute, testLogger(), testMetrics(),
+		proxy.WithKeyEncoder(encoder), proxy.WithMaxObjectBytes(50))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	adminSrv := httptest.NewServer(admin.NewAPI(c, h, testLogger()).Handler())
+	defer adminSrv.Close()
+
+	explain := func(query string) proxy.Explanation {
+		t.Helper()
+		resp, err := http.Get(adminSrv.URL + "/api/explain?" + query)
+		if err != nil {
+			t.Fatalf("explain failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var ex proxy.Explanation
+		if err := json.NewDecoder(resp.Body).Decode(&ex); err != nil {
+			t.Fatalf("failed to decode explanation: %v", err)
+		}
+		return ex
+	}
+	target := func(path string) string { return "url=" + url.QueryEscape(srv.URL+path) }
+
+	get(t, srv.URL+"/ok", nil)
+	ex := explain(target("/ok"))
+	if !ex.Cached || ex.TTLSeconds <= 0 || !strings.HasPrefix(ex.BackendKey, "gocache:v1:GET|") {
+		t.Errorf("expected /ok to be reported as cached: %+v", ex)
+	}
+	if ex.Fetch == nil || !ex.Fetch.Cacheable {
+		t.Errorf("expected /ok to be cacheable: %+v", ex.Fetch)
+	}
+
+	for path, want := range map[string][]string{
+		"/no-store": {"Cache-Control: no-store"},
+		"/private":  {"Cache-Control: private", "response sets a cookie (Set-Cookie)"},
+		"/vary":     {"Vary: Accept-Language is not part of the cache key"},
+		"/big":      {"body exceeds max_object_bytes (50)"},
+	} {
+		ex := explain(target(path))
+		if ex.Cached || ex.Fetch == nil || ex.Fetch.Cacheable || strings.Join(ex.Fetch.Reasons, ";") != strings.Join(want, ";") {
+			t.Errorf("%s: expected reasons %v, got %+v", path, want, ex.Fetch)
+		}
+	}
+
+	ex = explain(target("/ok") + "&method=DELETE")
+	if ex.RequestCacheable || ex.RequestReason == "" || ex.Fetch != nil {
+		t.Errorf("expected DELETE to be reported as not cacheable without a fetch: %+v", ex)
+	}
+}
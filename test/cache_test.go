@@ -1 +1,209 @@
-package test
\ No newline at end of file
+// File: test/cache_test.go
+package test
+
+import (
+	"bytes"
+	"errors"
+	"go-caching-proxy/internal/cache"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDiskCacheSurvivesRestart verifies that entries written by one DiskCache
+// are found by a new instance opened on the same directory, and that leftover
+// temporary files from an interrupted write are cleaned up.
+func TestDiskCacheSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := cache.NewDiskCache(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("failed to open disk cache: %v", err)
+	}
+	c.Set("GET|example.com|/big", cache.CacheEntry{
+		StatusCode: 200,
+		Headers:    map[string][]string{"Content-Type": {"application/octet-stream"}},
+		Body:       bytes.Repeat([]byte("x"), 4096),
+		ExpiresAt:  time.Now().Add(time.Minute),
+	})
+
+	// Simulate a crash in the middle of another write.
+	if err := os.WriteFile(filepath.Join(dir, "partial.tmp"), []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := cache.NewDiskCache(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("failed to reopen disk cache: %v", err)
+	}
+	entry, found := reopened.Get("GET|example.com|/big")
+	if !found {
+		t.Fatal("expected entry to survive a restart")
+	}
+	if len(entry.Body) != 4096 || entry.Headers.Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("entry was not restored intact: %d bytes, headers %v", len(entry.Body), entry.Headers)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "partial.tmp")); !os.IsNotExist(err) {
+		t.Error("expected leftover temporary file to be removed")
+	}
+}
+
+// TestDiskCacheByteBudget verifies that the least recently used entries are
+// evicted once the byte budget is exceeded.
+func TestDiskCacheByteBudget(t *testing.T) {
+	c, err := cache.NewDiskCache(t.TempDir(), 3000)
+	if err != nil {
+		t.Fatalf("failed to open disk cache: %v", err)
+	}
+	entry := cache.CacheEntry{
+		StatusCode: 200,
+		Body:       bytes.Repeat([]byte("x"), 1000),
+		ExpiresAt:  time.Now().Add(time.Minute),
+	}
+
+	c.Set("a", entry)
+	c.Set("b", entry)
+	c.Get("a") // "b" is now the least recently used
+	c.Set("c", entry)
+
+	if _, found := c.Get("b"); found {
+		t.Error("expected least recently used entry to be evicted")
+	}
+	if _, found := c.Get("a"); !found {
+		t.Error("expected recently used entry to be kept")
+	}
+	if c.Size() > 3000 {
+		t.Errorf("cache exceeds its byte budget: %d bytes", c.Size())
+	}
+}
+
+// TestDiskCacheConcurrentSetDelete verifies that racing Set and Delete calls
+// on the same keys leave the index consistent with the files on disk.
+func TestDiskCacheConcurrentSetDelete(t *testing.T) {
+	dir := t.TempDir()
+	c, err := cache.NewDiskCache(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("failed to open disk cache: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 20 {
+				key := "key-" + strconv.Itoa(i%2)
+				if (i+w)%2 == 0 {
+					c.Set(key, cache.CacheEntry{
+						StatusCode: 200,
+						Body:       bytes.Repeat([]byte("x"), 100*(w+1)),
+						ExpiresAt:  time.Now().Add(time.Minute),
+						Tags:       []string{"t"},
+					})
+				} else {
+					c.Delete(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var onDisk int64
+	files, _ := filepath.Glob(filepath.Join(dir, "*.entry"))
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		onDisk += info.Size()
+	}
+	if c.Size() != onDisk {
+		t.Errorf("index accounts for %d bytes, files hold %d", c.Size(), onDisk)
+	}
+	keys := c.Keys("")
+	if len(keys) != len(files) {
+		t.Errorf("index has %d keys, disk has %d files", len(keys), len(files))
+	}
+	for _, key := range keys {
+		if _, found := c.Get(key); !found {
+			t.Errorf("indexed key %q has no readable file", key)
+		}
+	}
+}
+
+// TestLRUSnapshotRestore verifies that a snapshot preserves recency order and
+// expiry times, and that expired entries are not restored.
+func TestLRUSnapshotRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lru.snapshot")
+	fresh := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	src := cache.NewLRUCache(3)
+	src.Set("a", cache.CacheEntry{StatusCode: 200, Body: []byte("a"), ExpiresAt: fresh})
+	src.Set("b", cache.CacheEntry{StatusCode: 200, Body: []byte("b"), ExpiresAt: fresh})
+	src.Set("stale", cache.CacheEntry{StatusCode: 200, ExpiresAt: time.Now().Add(-time.Second)})
+	src.Get("a") // "b" is now the least recently used live entry
+
+	if n, err := cache.SaveSnapshot(path, src); err != nil || n != 2 {
+		t.Fatalf("expected 2 entries saved, got %d (err %v)", n, err)
+	}
+
+	dst := cache.NewLRUCache(3)
+	if n, err := cache.LoadSnapshot(path, dst); err != nil || n != 2 {
+		t.Fatalf("expected 2 entries restored, got %d (err %v)", n, err)
+	}
+	entry, found := dst.Get("a")
+	if !found || !entry.ExpiresAt.Equal(fresh) {
+		t.Fatalf("expected entry 'a' with its original expiry, got %v", entry)
+	}
+
+	// Two more inserts must evict "b" first, proving the order survived.
+	dst.Set("c", cache.CacheEntry{ExpiresAt: fresh})
+	dst.Set("d", cache.CacheEntry{ExpiresAt: fresh})
+	if _, found := dst.Get("b"); found {
+		t.Error("expected least recently used entry 'b' to be evicted first")
+	}
+	if _, found := dst.Get("a"); !found {
+		t.Error("expected recently used entry 'a' to be kept")
+	}
+}
+
+// TestTieredSnapshotRestore verifies that a tiered cache snapshots and warms
+// its memory front.
+func TestTieredSnapshotRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tiered.snapshot")
+	newTiered := func() *cache.TieredCache {
+		back, err := cache.NewDiskCache(t.TempDir(), 1<<20)
+		if err != nil {
+			t.Fatalf("failed to open disk cache: %v", err)
+		}
+		return cache.NewTieredCache(cache.NewLRUCache(10), back)
+	}
+
+	src := newTiered()
+	src.Set("a", cache.CacheEntry{StatusCode: 200, Body: []byte("a"), ExpiresAt: time.Now().Add(time.Hour)})
+	if n, err := cache.SaveSnapshot(path, src); err != nil || n != 1 {
+		t.Fatalf("expected 1 entry saved, got %d (err %v)", n, err)
+	}
+
+	// The new cache's disk is empty, so a hit can only come from the front.
+	dst := newTiered()
+	if n, err := cache.LoadSnapshot(path, dst); err != nil || n != 1 {
+		t.Fatalf("expected 1 entry restored, got %d (err %v)", n, err)
+	}
+	if entry, found := dst.Get("a"); !found || string(entry.Body) != "a" {
+		t.Errorf("expected the restored entry in the front tier, got %v", entry)
+	}
+
+	// A front tier that can't be snapshotted is reported, not ignored.
+	front, err := cache.NewDiskCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("failed to open disk cache: %v", err)
+	}
+	other := cache.NewTieredCache(front, cache.NewLRUCache(1))
+	if _, err := cache.SaveSnapshot(filepath.Join(t.TempDir(), "x"), other); !errors.Is(err, cache.ErrSnapshotUnsupported) {
+		t.Errorf("expected ErrSnapshotUnsupported, got %v", err)
+	}
+}
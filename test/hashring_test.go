@@ -0,0 +1,37 @@
+// File: test/hashring_test.go
+package test
+
+import (
+	"fmt"
+	"go-caching-proxy/internal/hashring"
+	"testing"
+)
+
+// TestHashRingMinimalMovement verifies that removing a node from the ring only
+// moves the keys that node owned, and that weights skew the distribution.
+func TestHashRingMinimalMovement(t *testing.T) {
+	full := hashring.New(0)
+	full.Add("a", 1)
+	full.Add("b", 1)
+	full.Add("c", 2)
+
+	reduced := hashring.New(0)
+	reduced.Add("a", 1)
+	reduced.Add("c", 2)
+
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("GET|example.com|/items/%d", i)
+		owner := full.Get(key)
+		counts[owner]++
+
+		// Keys that were not on "b" must stay where they were.
+		if owner != "b" && reduced.Get(key) != owner {
+			t.Fatalf("key %q moved from %q to %q although its node was not removed", key, owner, reduced.Get(key))
+		}
+	}
+
+	if counts["c"] <= counts["a"] || counts["c"] <= counts["b"] {
+		t.Errorf("expected weight-2 node to own the most keys, got %v", counts)
+	}
+}
@@ -0,0 +1,60 @@
+// File: test/helpers_test.go
+package test
+
+import (
+	"go-caching-proxy/internal/metrics"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	sharedMetrics     *metrics.Metrics
+	sharedMetricsOnce sync.Once
+)
+
+// testMetrics returns a process-wide Metrics instance. metrics.New registers
+// its collectors globally, so it can only be called once per test binary.
+func testMetrics() *metrics.Metrics {
+	sharedMetricsOnce.Do(func() {
+		sharedMetrics = metrics.New()
+	})
+	return sharedMetrics
+}
+
+// testLogger returns a logger that discards its output.
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(io.Discard, nil))
+}
+
+// waitFor polls cond until it holds, failing the test after two seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// get performs a GET request with optional headers and returns the body.
+func get(t *testing.T, url string, headers map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request to proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
@@ -0,0 +1,130 @@
+// File: test/key_test.go
+package test
+
+import (
+	"go-caching-proxy/internal/config"
+	"go-caching-proxy/internal/key"
+	"go-caching-proxy/internal/route"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestKeyNormalization verifies that equivalent URLs collapse to one key.
+func TestKeyNormalization(t *testing.T) {
+	global := config.KeyConfig{
+		SortQuery:       true,
+		IgnoreParams:    []string{"utm_*", "fbclid"},
+		LowercaseHost:   true,
+		CollapseSlashes: true,
+	}
+	keys, err := route.NewKeyBuilder(global, nil)
+	if err != nil {
+		t.Fatalf("failed to build key builder: %v", err)
+	}
+
+	want := "GET|example.com|/a/b?a=1&b=2"
+	for _, tc := range []struct{ host, target string }{
+		{"example.com", "/a/b?b=2&a=1"},
+		{"EXAMPLE.com", "/a/b?a=1&utm_source=mail&b=2&fbclid=xyz"},
+		{"example.com", "//a///b?utm_campaign=x&b=2&a=1"},
+	} {
+		r := httptest.NewRequest("GET", tc.target, nil)
+		r.Host = tc.host
+		if got := keys.Generate(r); got != want {
+			t.Errorf("%s%s: expected key %q, got %q", tc.host, tc.target, want, got)
+		}
+	}
+
+	// A route's allowlist drops everything else, on top of the global rules.
+	routeKeys, err := route.NewKeyBuilder(global, &config.KeyConfig{KeepParams: []string{"page"}})
+	if err != nil {
+		t.Fatalf("failed to build route key builder: %v", err)
+	}
+	a := routeKeys.Generate(httptest.NewRequest("GET", "/list?page=2&sessionid=1", nil))
+	b := routeKeys.Generate(httptest.NewRequest("GET", "/list?sessionid=2&page=2", nil))
+	if a != b || a != "GET|example.com|/list?page=2" {
+		t.Errorf("expected allowlisted key 'GET|example.com|/list?page=2', got %q and %q", a, b)
+	}
+}
+
+// TestKeyEncoder verifies namespacing, hashing and generation bumps.
+func TestKeyEncoder(t *testing.T) {
+	enc, err := key.NewEncoder("fleet-a", 3, key.HashSHA256)
+	if err != nil {
+		t.Fatalf("failed to create encoder: %v", err)
+	}
+	raw := "GET|example.com|/very/long/url?with=query"
+
+	first := enc.Encode(raw)
+	if !strings.HasPrefix(first, "fleet-a:v3:") || len(first) != len("fleet-a:v3:")+64 {
+		t.Errorf("expected namespaced SHA-256 key, got %q", first)
+	}
+	if enc.Encode(raw) != first {
+		t.Error("expected encoding to be deterministic")
+	}
+
+	other, _ := key.NewEncoder("fleet-b", 3, key.HashSHA256)
+	if other.Encode(raw) == first {
+		t.Error("expected different namespaces to produce different keys")
+	}
+
+	if gen := enc.Bump(); gen != 4 {
+		t.Errorf("expected generation 4 after bump, got %d", gen)
+	}
+	if enc.Encode(raw) == first {
+		t.Error("expected a generation bump to change every key")
+	}
+	// A reload with the configured generation must not undo the bump.
+	if enc.RaiseGeneration(3) || enc.Generation() != 4 {
+		t.Errorf("expected a lower generation to be ignored, got %d", enc.Generation())
+	}
+	if !enc.RaiseGeneration(5) || enc.Generation() != 5 {
+		t.Errorf("expected a higher generation to be applied, got %d", enc.Generation())
+	}
+
+	if _, err := key.NewEncoder("", 0, "md5"); err == nil {
+		t.Error("expected unknown hash to be rejected")
+	}
+}
+
+// TestKeyTemplateEscaping verifies that a "|" in a placeholder's value can't
+// make two requests share a key.
+func TestKeyTemplateEscaping(t *testing.T) {
+	tmpl := key.MustParseTemplate("{path}|{header:X-Tenant}|{header:X-Region}")
+	keyFor := func(tenant, region string) string {
+		r := httptest.NewRequest("GET", "/a", nil)
+		r.Header.Set("X-Tenant", tenant)
+		r.Header.Set("X-Region", region)
+		return tmpl.Generate(r)
+	}
+
+	if a, b := keyFor("a|b", "c"), keyFor("a", "b|c"); a == b {
+		t.Errorf("expected distinct keys, both are %q", a)
+	}
+	if a, b := keyFor(`a\`, "|b"), keyFor(`a\|`, "b"); a == b {
+		t.Errorf("expected distinct keys, both are %q", a)
+	}
+	if got := keyFor("acme", "eu"); got != "/a|acme|eu" {
+		t.Errorf("expected values without separators to be unchanged, got %q", got)
+	}
+}
+
+func TestKeyTemplateVariesOn(t *testing.T) {
+	tests := []struct {
+		template string
+		header   string
+		want     bool
+	}{
+		{"{path}|{header:Accept-Language}", "accept-language", true},
+		{"{path}|{header:Accept-Language}", "Accept-Encoding", false},
+		{"{path}|{header:Cookie}", "Cookie", true},
+		// A single cookie doesn't cover responses that Vary on the whole header.
+		{"{path}|{cookie:session}", "Cookie", false},
+	}
+	for _, tt := range tests {
+		if got := key.MustParseTemplate(tt.template).VariesOn(tt.header); got != tt.want {
+			t.Errorf("%q VariesOn(%q) = %v, want %v", tt.template, tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// File: test/memcached_test.go
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go-caching-proxy/internal/cache"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMemcached is a tiny in-process memcached server that understands just
+// enough of the text protocol (version, get, set, delete) for the tests, and
+// enforces an item size limit like the real server.
+type fakeMemcached struct {
+	ln       net.Listener
+	itemSize int
+
+	mu    sync.Mutex
+	items map[string][]byte
+}
+
+func newFakeMemcached(t *testing.T, itemSize int) *fakeMemcached {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	f := &fakeMemcached{ln: ln, itemSize: itemSize, items: make(map[string][]byte)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeMemcached) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeMemcached) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for k := range f.items {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (f *fakeMemcached) delete(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.items, key)
+}
+
+func (f *fakeMemcached) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "version":
+			w.WriteString("VERSION fake\r\n")
+		case "get":
+			f.mu.Lock()
+			for _, k := range fields[1:] {
+				if v, ok := f.items[k]; ok {
+					fmt.Fprintf(w, "VALUE %s 0 %d\r\n", k, len(v))
+					w.Write(v)
+					w.WriteString("\r\n")
+				}
+			}
+			f.mu.Unlock()
+			w.WriteString("END\r\n")
+		case "set":
+			size, _ := strconv.Atoi(fields[4])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			if size > f.itemSize {
+				w.WriteString("SERVER_ERROR object too large for cache\r\n")
+				break
+			}
+			f.mu.Lock()
+			f.items[fields[1]] = data[:size]
+			f.mu.Unlock()
+			w.WriteString("STORED\r\n")
+		case "delete":
+			f.mu.Lock()
+			_, ok := f.items[fields[1]]
+			delete(f.items, fields[1])
+			f.mu.Unlock()
+			if ok {
+				w.WriteString("DELETED\r\n")
+			} else {
+				w.WriteString("NOT_FOUND\r\n")
+			}
+		default:
+			w.WriteString("ERROR\r\n")
+		}
+		w.Flush()
+	}
+}
+
+// TestMemcachedChunking verifies that bodies larger than the item limit are
+// chunked and reassembled, and that losing a chunk turns into a miss.
+func TestMemcachedChunking(t *testing.T) {
+	const itemSize = 64 * 1024
+	servers := []*fakeMemcached{newFakeMemcached(t, itemSize), newFakeMemcached(t, itemSize)}
+	c, err := cache.NewMemcachedCache([]string{servers[0].addr(), servers[1].addr()}, itemSize, time.Second)
+	if err != nil {
+		t.Fatalf("failed to create memcached cache: %v", err)
+	}
+
+	big := bytes.Repeat([]byte("0123456789"), 50*1024) // 500KB, several chunks
+	c.Set("GET|example.com|/artifact.tar.gz", cache.CacheEntry{
+		StatusCode: 200,
+		Body:       big,
+		ExpiresAt:  time.Now().Add(time.Minute),
+	})
+	c.Set("GET|example.com|/small", cache.CacheEntry{
+		StatusCode: 200,
+		Body:       []byte("small"),
+		ExpiresAt:  time.Now().Add(time.Minute),
+	})
+
+	entry, found := c.Get("GET|example.com|/artifact.tar.gz")
+	if !found || !bytes.Equal(entry.Body, big) {
+		t.Fatal("expected large entry to be reassembled from its chunks")
+	}
+	if entry, found := c.Get("GET|example.com|/small"); !found || string(entry.Body) != "small" {
+		t.Fatal("expected small entry to be stored as a single item")
+	}
+
+	// Drop one chunk of the large entry from whichever server holds it.
+	for _, srv := range servers {
+		for _, k := range srv.keys() {
+			if strings.HasSuffix(k, ":1") {
+				srv.delete(k)
+			}
+		}
+	}
+	if _, found := c.Get("GET|example.com|/artifact.tar.gz"); found {
+		t.Error("expected a missing chunk to be reported as a miss")
+	}
+}
+
+// TestMemcachedSmallItemSize verifies that item sizes too small to hold a
+// chunk are rejected, and that at the smallest accepted size every chunk
+// fits in one item.
+func TestMemcachedSmallItemSize(t *testing.T) {
+	if _, err := cache.NewMemcachedCache([]string{"127.0.0.1:1"}, 16*1024, time.Second); err == nil {
+		t.Fatal("expected a 16KB item size to be rejected")
+	}
+
+	srv := newFakeMemcached(t, cache.MinMemcachedItemSize)
+	c, err := cache.NewMemcachedCache([]string{srv.addr()}, cache.MinMemcachedItemSize, time.Second)
+	if err != nil {
+		t.Fatalf("failed to create memcached cache: %v", err)
+	}
+	body := bytes.Repeat([]byte("0123456789"), 10*1024)
+	c.Set("GET|example.com|/report.pdf", cache.CacheEntry{StatusCode: 200, Body: body, ExpiresAt: time.Now().Add(time.Minute)})
+	if entry, found := c.Get("GET|example.com|/report.pdf"); !found || !bytes.Equal(entry.Body, body) {
+		t.Fatal("expected the entry to be chunked into items the server accepts")
+	}
+}
@@ -3,7 +3,6 @@ package test
 
 import (
 	"go-caching-proxy/internal/cache"
-	"go-caching-proxy/internal/metrics" // <-- 1. IMPORT METRICS
 	"go-caching-proxy/internal/proxy"
 	"io"
 	"log/slog"
@@ -30,7 +29,7 @@ func TestProxyCacheHitAndMiss(t *testing.T) {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	appCache := cache.NewLRUCache(10)
 	defaultTTL := 1 * time.Minute
-	mets := metrics.New() // <-- 2. CREATE A VALID METRICS OBJECT
+	mets := testMetrics() // <-- 2. CREATE A VALID METRICS OBJECT (shared, see helpers_test.go)
 
 	// 3. Create the real proxy handler, configured to use our mock server
 	//    Pass the 'mets' object instead of 'nil'
@@ -1 +1,572 @@
-package test
\ No newline at end of file
+// File: test/proxy_test.go
+package test
+
+import (
+	"compress/gzip"
+	"go-caching-proxy/internal/cache"
+	"go-caching-proxy/internal/config"
+	"go-caching-proxy/internal/proxy"
+	"go-caching-proxy/internal/route"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// TestRouteKeyTemplate verifies that a route's key template keeps responses
+// for different X-Api-Version headers apart, while other paths still use the
+// default key and ignore the header.
+func TestRouteKeyTemplate(t *testing.T) {
+	var originHits int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&originHits, 1)
+		w.Write([]byte("version " + r.Header.Get("X-Api-Version")))
+	}))
+	defer origin.Close()
+
+	routes, err := route.New([]config.Route{{
+		Name:       "versioned",
+		PathPrefix: "/api/",
+		Key:        config.KeyConfig{Template: "{method}|{host}|{url}|{header:X-Api-Version}"},
+	}}, config.KeyConfig{})
+	if err != nil {
+		t.Fatalf("failed to build routes: %v", err)
+	}
+	h, err := proxy.NewHandler(origin.URL, cache.NewLRUCache(10), time.Minute, testLogger(), testMetrics(),
+		proxy.WithRoutes(routes))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	if _, body := get(t, srv.URL+"/api/items", map[string]string{"X-Api-Version": "1"}); body != "version 1" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if _, body := get(t, srv.URL+"/api/items", map[string]string{"X-Api-Version": "2"}); body != "version 2" {
+		t.Errorf("expected version 2 response not to be served from version 1's entry, got %q", body)
+	}
+	if _, body := get(t, srv.URL+"/api/items", map[string]string{"X-Api-Version": "1"}); body != "version 1" {
+		t.Errorf("expected cached version 1 response, got %q", body)
+	}
+	if hits := atomic.LoadInt32(&originHits); hits != 2 {
+		t.Errorf("expected 2 origin hits, got %d", hits)
+	}
+
+	// Outside the route the header is not part of the key.
+	get(t, srv.URL+"/other", map[string]string{"X-Api-Version": "1"})
+	if _, body := get(t, srv.URL+"/other", map[string]string{"X-Api-Version": "2"}); body != "version 1" {
+		t.Errorf("expected default key to ignore the header, got %q", body)
+	}
+}
+
+// TestPostCaching verifies that POST requests on an opted-in route are cached
+// by body, that the body reaches the origin on a miss, and that JSON bodies
+// differing only in formatting share an entry.
+func TestPostCaching(t *testing.T) {
+	var originHits int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&originHits, 1)
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte("echo " + string(body)))
+	}))
+	defer origin.Close()
+
+	routes, err := route.New([]config.Route{{
+		Name:       "graphql",
+		PathPrefix: "/graphql",
+		Post:       config.PostConfig{Enabled: true, MaxBodyBytes: 64, CanonicalJSON: true},
+	}}, config.KeyConfig{})
+	if err != nil {
+		t.Fatalf("failed to build routes: %v", err)
+	}
+	h, err := proxy.NewHandler(origin.URL, cache.NewLRUCache(10), time.Minute, testLogger(), testMetrics(),
+		proxy.WithRoutes(routes))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	post := func(path, body string) string {
+		resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("request to proxy failed: %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+
+	if got := post("/graphql", `{"query":"{a}","vars":{"x":1}}`); got != `echo {"query":"{a}","vars":{"x":1}}` {
+		t.Fatalf("expected origin to receive the body, got %q", got)
+	}
+	post("/graphql", `{ "vars": {"x": 1}, "query": "{a}" }`)
+	if hits := atomic.LoadInt32(&originHits); hits != 1 {
+		t.Errorf("expected equivalent JSON to hit the cache, origin hit %d times", hits)
+	}
+
+	post("/graphql", `{"query":"{b}"}`)
+	if hits := atomic.LoadInt32(&originHits); hits != 2 {
+		t.Errorf("expected a different query to miss, origin hit %d times", hits)
+	}
+
+	// Over the size limit: forwarded intact and never cached.
+	large := `{"query":"` + strings.Repeat("x", 100) + `"}`
+	if got := post("/graphql", large); got != "echo "+large {
+		t.Errorf("expected large body to be forwarded intact, got %q", got)
+	}
+	post("/graphql", large)
+	if hits := atomic.LoadInt32(&originHits); hits != 4 {
+		t.Errorf("expected oversized bodies to bypass the cache, origin hit %d times", hits)
+	}
+
+	// POST on other paths is never cached.
+	post("/other", `{}`)
+	post("/other", `{}`)
+	if hits := atomic.LoadInt32(&originHits); hits != 6 {
+		t.Errorf("expected POST outside the route to bypass the cache, origin hit %d times", hits)
+	}
+}
+
+// TestSurrogateKeyPurge verifies that tags are stripped from client responses,
+// that purging a tag removes every entry carrying it, and that origins can't
+// use the reserved URL tags.
+func TestSurrogateKeyPurge(t *testing.T) {
+	var originHits int32
+	var proxyHost atomic.Value
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&originHits, 1)
+		switch r.URL.Path {
+		case "/products/123":
+			// The forged tag would make purging /products/456 purge this too.
+			w.Header().Set("Surrogate-Key", "product-123 catalog url:"+proxyHost.Load().(string)+"/products/456")
+		case "/products/123/reviews":
+			w.Header().Set("Cache-Tag", "product-123")
+		default:
+			w.Header().Set("Surrogate-Key", "catalog")
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	h, err := proxy.NewHandler(origin.URL, cache.NewLRUCache(10), time.Minute, testLogger(), testMetrics())
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	proxyHost.Store(strings.TrimPrefix(srv.URL, "http://"))
+
+	for _, path := range []string{"/products/123", "/products/123/reviews", "/products/456"} {
+		for i := 0; i < 2; i++ { // miss, then hit
+			resp, _ := get(t, srv.URL+path, nil)
+			if resp.Header.Get("Surrogate-Key") != "" || resp.Header.Get("Cache-Tag") != "" {
+				t.Errorf("%s: tag headers leaked to the client: %v", path, resp.Header)
+			}
+		}
+	}
+	if hits := atomic.LoadInt32(&originHits); hits != 3 {
+		t.Fatalf("expected 3 origin hits before the purge, got %d", hits)
+	}
+
+	if n, err := h.PurgeTag("product-123"); err != nil || n != 2 {
+		t.Fatalf("expected 2 entries purged, got %d (err %v)", n, err)
+	}
+	get(t, srv.URL+"/products/123", nil)
+	get(t, srv.URL+"/products/123/reviews", nil)
+	get(t, srv.URL+"/products/456", nil)
+	if hits := atomic.LoadInt32(&originHits); hits != 5 {
+		t.Errorf("expected only the purged entries to be refetched, origin hit %d times", hits)
+	}
+
+	if n, err := h.PurgeTag("url:" + proxyHost.Load().(string) + "/products/456"); err != nil || n != 1 {
+		t.Errorf("expected only /products/456 to carry its URL tag, purged %d (err %v)", n, err)
+	}
+
+	// An entry overwritten with different tags must leave the sets of the
+	// tags it no longer carries.
+	redisCache, err := cache.NewRedisCache(miniredis.RunT(t).Addr(), "", 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create redis cache: %v", err)
+	}
+	for name, c := range map[string]interface {
+		cache.Storer
+		cache.Tagger
+	}{"lru": cache.NewLRUCache(10), "redis": redisCache} {
+		expires := time.Now().Add(time.Minute)
+		c.Set("/products/789", cache.CacheEntry{StatusCode: 200, Body: []byte("v1"), Tags: []string{"product-789", "sale"}, ExpiresAt: expires})
+		c.Set("/products/789", cache.CacheEntry{StatusCode: 200, Body: []byte("v2"), Tags: []string{"product-789"}, ExpiresAt: expires})
+		if n := c.PurgeTag("sale"); n != 0 {
+			t.Errorf("%s: expected a dropped tag to purge nothing, purged %d", name, n)
+		}
+		if entry, found := c.Get("/products/789"); !found || string(entry.Body) != "v2" {
+			t.Errorf("%s: expected the overwritten entry to survive a purge of its dropped tag", name)
+		}
+		if n := c.PurgeTag("product-789"); n != 1 {
+			t.Errorf("%s: expected the entry's current tag to purge it, purged %d", name, n)
+		}
+	}
+}
+
+// TestPurgeAndBan verifies that PURGE removes every variant of a URL, that
+// BAN removes URLs by prefix, and that clients outside the ACL are refused.
+func TestPurgeAndBan(t *testing.T) {
+	var originHits int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&originHits, 1)
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	routes, err := route.New([]config.Route{{
+		Name:       "versioned",
+		PathPrefix: "/api/",
+		Key:        config.KeyConfig{Template: "{method}|{host}|{url}|{header:X-Api-Version}"},
+	}}, config.KeyConfig{})
+	if err != nil {
+		t.Fatalf("failed to build routes: %v", err)
+	}
+	acl, err := proxy.NewPurgeACL(nil, "secret")
+	if err != nil {
+		t.Fatalf("failed to build purge ACL: %v", err)
+	}
+	h, err := proxy.NewHandler(origin.URL, cache.NewLRUCache(10), time.Minute, testLogger(), testMetrics(),
+		proxy.WithRoutes(routes), proxy.WithPurgeACL(acl))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	send := func(method, path string, headers map[string]string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+	warm := func() {
+		for _, v := range []string{"1", "2"} {
+			get(t, srv.URL+"/api/items", map[string]string{"X-Api-Version": v})
+		}
+		get(t, srv.URL+"/api/users", nil)
+		get(t, srv.URL+"/static/app.js", nil)
+	}
+
+	warm()
+	if hits := atomic.LoadInt32(&originHits); hits != 4 {
+		t.Fatalf("expected 4 origin hits after warming, got %d", hits)
+	}
+
+	if resp := send(proxy.MethodPurge, "/api/items", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 without a token, got %d", resp.StatusCode)
+	}
+
+	auth := map[string]string{"X-Purge-Token": "secret"}
+	if resp := send(proxy.MethodPurge, "/api/items", auth); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected PURGE to succeed, got %d", resp.StatusCode)
+	}
+	warm()
+	if hits := atomic.LoadInt32(&originHits); hits != 6 {
+		t.Errorf("expected both variants of the purged URL to be refetched, origin hit %d times", hits)
+	}
+
+	if resp := send(proxy.MethodBan, "/", map[string]string{"X-Purge-Token": "secret", "X-Ban-Prefix": "/api/"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected BAN to succeed, got %d", resp.StatusCode)
+	}
+	warm()
+	if hits := atomic.LoadInt32(&originHits); hits != 9 {
+		t.Errorf("expected only URLs under /api/ to be refetched after BAN, origin hit %d times", hits)
+	}
+}
+
+// TestUnsafeMethodInvalidation verifies that a successful unsafe request
+// invalidates every variant of its target and of a same-host Location, but
+// that failed requests and other hosts' URLs are left alone.
+func TestUnsafeMethodInvalidation(t *testing.T) {
+	var originHits int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&originHits, 1)
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/items/42":
+			w.Header().Set("Location", "/items")
+			w.Header().Set("Content-Location", "http://other.example/items/7")
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	h, err := proxy.NewHandler(origin.URL, cache.NewLRUCache(10), time.Minute, testLogger(), testMetrics())
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	send := func(method, path string) {
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	warm := func() {
+		get(t, srv.URL+"/items/42", nil)
+		get(t, srv.URL+"/items/42?fields=name", nil)
+		get(t, srv.URL+"/items", nil)
+		get(t, srv.URL+"/items/7", nil)
+	}
+
+	warm()
+	warm()
+	if hits := atomic.LoadInt32(&originHits); hits != 4 {
+		t.Fatalf("expected 4 origin hits after warming, got %d", hits)
+	}
+
+	// A failed DELETE must not invalidate anything.
+	send(http.MethodDelete, "/items/42")
+	warm()
+	if hits := atomic.LoadInt32(&originHits); hits != 5 {
+		t.Errorf("expected a failed DELETE to leave the cache alone, origin hit %d times", hits)
+	}
+
+	// A successful PUT invalidates /items/42 and its Location, /items.
+	// /items/42?fields=name is a different URI and stays cached.
+	send(http.MethodPut, "/items/42")
+	warm()
+	if hits := atomic.LoadInt32(&originHits); hits != 8 {
+		t.Errorf("expected the target and Location to be refetched, origin hit %d times", hits)
+	}
+}
+
+// TestNegativeCaching verifies that configured error statuses and transport
+// failures are cached, other errors are not, and that negative entries don't
+// replace good ones.
+func TestNegativeCaching(t *testing.T) {
+	hits := make(map[string]*int32)
+	for _, p := range []string{"/flaky", "/teapot", "/broken", "/good"} {
+		hits[p] = new(int32)
+	}
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits[r.URL.Path], 1)
+		switch r.URL.Path {
+		case "/flaky":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/teapot":
+			w.WriteHeader(http.StatusTeapot)
+		case "/broken":
+			// Drop the connection without answering.
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer origin.Close()
+
+	negative, err := proxy.NewNegativeTTLs(map[string]int{"5xx": 60}, 60)
+	if err != nil {
+		t.Fatalf("failed to parse negative TTLs: %v", err)
+	}
+	c := cache.NewLRUCache(10)
+	h, err := proxy.NewHandler(origin.URL, c, time.Minute, testLogger(), testMetrics(),
+		proxy.WithNegativeCaching(negative))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for path, want := range map[string]struct {
+		status int
+		cached bool
+	}{
+		"/flaky":  {http.StatusServiceUnavailable, true},
+		"/teapot": {http.StatusTeapot, false},
+		"/broken": {http.StatusBadGateway, true},
+	} {
+		var first int32
+		for i := 0; i < 2; i++ {
+			if resp, _ := get(t, srv.URL+path, nil); resp.StatusCode != want.status {
+				t.Errorf("%s: expected status %d, got %d", path, want.status, resp.StatusCode)
+			}
+			if i == 0 {
+				// The transport may retry a dropped connection itself.
+				first = atomic.LoadInt32(hits[path])
+			}
+		}
+		if cached := atomic.LoadInt32(hits[path]) == first; cached != want.cached {
+			t.Errorf("%s: expected cached=%v, origin hit %d times", path, want.cached, atomic.LoadInt32(hits[path]))
+		}
+	}
+
+	// A good response is stored under its own key, untouched by the
+	// negative entry of the same URL.
+	get(t, srv.URL+"/good", nil)
+	c.Set("GET|"+srv.Listener.Addr().String()+"|/good|negative", cache.CacheEntry{
+		StatusCode: http.StatusServiceUnavailable,
+		ExpiresAt:  time.Now().Add(time.Minute),
+	})
+	if resp, _ := get(t, srv.URL+"/good", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the good entry to win over the negative one, got %d", resp.StatusCode)
+	}
+
+	if _, err := proxy.NewNegativeTTLs(map[string]int{"5x": 1}, 0); err == nil {
+		t.Error("expected an invalid status class to be rejected")
+	}
+}
+
+// TestStaleOverNegative verifies that a stale entry is served in preference
+// to a negative entry for the same URL, and instead of a transport error,
+// which then isn't cached.
+func TestStaleOverNegative(t *testing.T) {
+	var originHits int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&originHits, 1)
+		// Drop the connection without answering.
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer origin.Close()
+
+	negative, err := proxy.NewNegativeTTLs(map[string]int{"5xx": 60}, 60)
+	if err != nil {
+		t.Fatalf("failed to parse negative TTLs: %v", err)
+	}
+	c := cache.NewLRUCache(10)
+	h, err := proxy.NewHandler(origin.URL, c, time.Minute, testLogger(), testMetrics(),
+		proxy.WithNegativeCaching(negative), proxy.WithStale(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	keyFor := func(path string) string { return "GET|" + srv.Listener.Addr().String() + "|" + path }
+	for _, path := range []string{"/negative", "/down"} {
+		c.Set(keyFor(path), cache.CacheEntry{
+			StatusCode: http.StatusOK,
+			Headers:    http.Header{},
+			Body:       []byte("stale"),
+			FreshUntil: time.Now().Add(-time.Minute),
+			ExpiresAt:  time.Now().Add(time.Hour),
+		})
+	}
+	c.Set(keyFor("/negative")+"|negative", cache.CacheEntry{
+		StatusCode: http.StatusServiceUnavailable,
+		Headers:    http.Header{},
+		ExpiresAt:  time.Now().Add(time.Minute),
+	})
+
+	if resp, body := get(t, srv.URL+"/negative", nil); resp.StatusCode != http.StatusOK || body != "stale" {
+		t.Errorf("expected the stale entry to win over the negative one, got %d %q", resp.StatusCode, body)
+	}
+	if hits := atomic.LoadInt32(&originHits); hits != 0 {
+		t.Errorf("expected the origin not to be asked while a negative entry is cached, got %d hits", hits)
+	}
+
+	if resp, body := get(t, srv.URL+"/down", nil); resp.StatusCode != http.StatusOK || body != "stale" {
+		t.Errorf("expected the stale entry instead of a transport error, got %d %q", resp.StatusCode, body)
+	}
+	if _, found := c.Get(keyFor("/down") + "|negative"); found {
+		t.Error("expected no negative entry to be cached when a stale entry was served")
+	}
+}
+
+// TestVaryAcceptEncoding verifies that responses varying on Accept-Encoding
+// are cached, and stored uncompressed so that every client can read them.
+func TestVaryAcceptEncoding(t *testing.T) {
+	var originHits int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&originHits, 1)
+		w.Header().Set("Vary", "Accept-Encoding")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Write([]byte("plain text"))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte("plain text"))
+		zw.Close()
+	}))
+	defer origin.Close()
+
+	h, err := proxy.NewHandler(origin.URL, cache.NewLRUCache(10), time.Minute, testLogger(), testMetrics())
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for _, encoding := range []string{"gzip", "identity", ""} {
+		resp, body := get(t, srv.URL+"/page", map[string]string{"Accept-Encoding": encoding})
+		if body != "plain text" || resp.Header.Get("Content-Encoding") != "" {
+			t.Errorf("Accept-Encoding %q: expected the uncompressed body, got %q (Content-Encoding %q)",
+				encoding, body, resp.Header.Get("Content-Encoding"))
+		}
+	}
+	if hits := atomic.LoadInt32(&originHits); hits != 1 {
+		t.Errorf("expected the response to be cached once for every client, origin hit %d times", hits)
+	}
+}
+
+// TestUncacheableResponses verifies that private responses, responses that
+// set a cookie and responses over max_object_bytes reach the client but are
+// not stored.
+func TestUncacheableResponses(t *testing.T) {
+	var originHits int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&originHits, 1)
+		switch r.URL.Path {
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/cookie":
+			w.Header().Set("Set-Cookie", "session=abc")
+		case "/big":
+			w.Write([]byte(strings.Repeat("x", 100)))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	c := cache.NewLRUCache(10)
+	h, err := proxy.NewHandler(origin.URL, c, time.Minute, testLogger(), testMetrics(), proxy.WithMaxObjectBytes(50))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for path, want := range map[string]string{"/private": "ok", "/cookie": "ok", "/big": strings.Repeat("x", 100)} {
+		before := atomic.LoadInt32(&originHits)
+		for i := 0; i < 2; i++ {
+			if resp, body := get(t, srv.URL+path, nil); resp.StatusCode != http.StatusOK || body != want {
+				t.Errorf("%s: expected the origin's response, got %d %q", path, resp.StatusCode, body)
+			}
+		}
+		if hits := atomic.LoadInt32(&originHits) - before; hits != 2 {
+			t.Errorf("%s: expected both requests to reach the origin, got %d", path, hits)
+		}
+		if _, found := c.Get("GET|" + srv.Listener.Addr().String() + "|" + path); found {
+			t.Errorf("%s: expected the response not to be stored", path)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// File: test/redis_test.go
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"go-caching-proxy/internal/cache"
+	"go-caching-proxy/internal/hashring"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// TestRedisRingRebalancing verifies that adding a node to the ring only moves
+// the keys the new node now owns, that removing it brings them back, that
+// unchanged nodes keep their connections, and that a removed node's
+// connections are closed.
+func TestRedisRingRebalancing(t *testing.T) {
+	servers := map[string]*miniredis.Miniredis{}
+	node := func(name string) cache.RingNode {
+		if servers[name] == nil {
+			servers[name] = miniredis.RunT(t)
+		}
+		return cache.RingNode{Name: name, Address: servers[name].Addr(), Weight: 1}
+	}
+
+	c, err := cache.NewRedisRingCache([]cache.RingNode{node("a"), node("b")}, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create ring: %v", err)
+	}
+	keys := make([]string, 200)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("GET|example.com|/items/%d", i)
+		c.Set(keys[i], cache.CacheEntry{StatusCode: 200, Body: []byte(keys[i]), ExpiresAt: time.Now().Add(time.Minute)})
+	}
+	connections := map[string]int{"a": servers["a"].TotalConnectionCount(), "b": servers["b"].TotalConnectionCount()}
+
+	if err := c.SetNodes([]cache.RingNode{node("a"), node("b"), node("c")}); err != nil {
+		t.Fatalf("failed to add a node: %v", err)
+	}
+	expected := hashring.New(0)
+	for _, name := range []string{"a", "b", "c"} {
+		expected.Add(name, 1)
+	}
+	moved := 0
+	for _, k := range keys {
+		_, hit := c.Get(k)
+		if toNew := expected.Get(k) == "c"; hit == toNew {
+			t.Errorf("key %q: hit=%v although it moved to the new node=%v", k, hit, toNew)
+		}
+		if expected.Get(k) == "c" {
+			moved++
+		}
+	}
+	if moved == 0 || moved == len(keys) {
+		t.Errorf("expected the new node to take some of the keys, it took %d of %d", moved, len(keys))
+	}
+	for name, n := range connections {
+		if got := servers[name].TotalConnectionCount(); got != n {
+			t.Errorf("node %s: expected its connections to be reused, %d new ones were opened", name, got-n)
+		}
+	}
+
+	// Removing the node moves its keys back to where they are still stored,
+	// and closes its connections.
+	if err := c.SetNodes([]cache.RingNode{node("a"), node("b")}); err != nil {
+		t.Fatalf("failed to remove a node: %v", err)
+	}
+	for _, k := range keys {
+		if entry, ok := c.Get(k); !ok || string(entry.Body) != k {
+			t.Errorf("key %q: expected a hit after removing the new node", k)
+		}
+	}
+	waitFor(t, "the removed node's connections to close", func() bool {
+		return servers["c"].CurrentConnectionCount() == 0
+	})
+}
+
+// TestRedisRingConcurrentRebalancing verifies that calls racing with
+// SetNodes keep working on the node they resolved, even when it is removed
+// meanwhile.
+func TestRedisRingConcurrentRebalancing(t *testing.T) {
+	a, b := miniredis.RunT(t), miniredis.RunT(t)
+	both := []cache.RingNode{{Name: "a", Address: a.Addr()}, {Name: "b", Address: b.Addr()}}
+	c, err := cache.NewRedisRingCache(both, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create ring: %v", err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				k := fmt.Sprintf("key-%d-%d", w, i%50)
+				c.Set(k, cache.CacheEntry{StatusCode: 200, Body: []byte(k), ExpiresAt: time.Now().Add(time.Minute)})
+				c.Get(k)
+				c.Keys("key-")
+			}
+		}()
+	}
+	for i := range 20 {
+		nodes := both
+		if i%2 == 0 {
+			nodes = both[:1]
+		}
+		if err := c.SetNodes(nodes); err != nil {
+			t.Fatalf("failed to set nodes: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+
+	if err := c.SetNodes(both); err != nil {
+		t.Fatalf("failed to set nodes: %v", err)
+	}
+	c.Set("final", cache.CacheEntry{StatusCode: 200, Body: []byte("ok"), ExpiresAt: time.Now().Add(time.Minute)})
+	if entry, ok := c.Get("final"); !ok || string(entry.Body) != "ok" {
+		t.Error("expected the ring to keep working after concurrent rebalancing")
+	}
+}
+
+// TestRedisChunking verifies that bodies larger than the chunk size are split
+// over several keys and reassembled, that Keys and Delete handle the chunks,
+// and that losing a chunk turns into a miss.
+func TestRedisChunking(t *testing.T) {
+	srv := miniredis.RunT(t)
+	c, err := cache.NewRedisCache(srv.Addr(), "", 0, 64*1024)
+	if err != nil {
+		t.Fatalf("failed to create redis cache: %v", err)
+	}
+
+	big := bytes.Repeat([]byte("0123456789"), 50*1024) // 500KB, several chunks
+	c.Set("GET|example.com|/artifact.tar.gz", cache.CacheEntry{StatusCode: 200, Body: big, ExpiresAt: time.Now().Add(time.Minute)})
+	c.Set("GET|example.com|/small", cache.CacheEntry{StatusCode: 200, Body: []byte("small"), ExpiresAt: time.Now().Add(time.Minute)})
+
+	var chunks []string
+	for _, k := range srv.Keys() {
+		if strings.HasPrefix(k, "GET|example.com|/artifact.tar.gz#chunk:") {
+			chunks = append(chunks, k)
+			if ttl := srv.TTL(k); ttl <= 0 {
+				t.Errorf("chunk %q has no expiry", k)
+			}
+		}
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("expected the large body to be split into chunks, got keys %v", srv.Keys())
+	}
+
+	entry, found := c.Get("GET|example.com|/artifact.tar.gz")
+	if !found || !bytes.Equal(entry.Body, big) {
+		t.Fatal("expected large entry to be reassembled from its chunks")
+	}
+	if entry, found := c.Get("GET|example.com|/small"); !found || string(entry.Body) != "small" {
+		t.Fatal("expected small entry to be stored as a single value")
+	}
+	if keys := c.Keys("GET|example.com|"); len(keys) != 2 {
+		t.Errorf("expected Keys to list the 2 entries without their chunks, got %v", keys)
+	}
+
+	srv.Del(chunks[1])
+	if _, found := c.Get("GET|example.com|/artifact.tar.gz"); found {
+		t.Error("expected a missing chunk to be reported as a miss")
+	}
+
+	c.Delete("GET|example.com|/artifact.tar.gz")
+	for _, k := range chunks {
+		if srv.Exists(k) {
+			t.Errorf("expected chunk %q to be deleted with its entry", k)
+		}
+	}
+}
+
+func TestRedisChunkOverwrite(t *testing.T) {
+	srv := miniredis.RunT(t)
+	c, err := cache.NewRedisCache(srv.Addr(), "", 0, 64*1024)
+	if err != nil {
+		t.Fatalf("failed to create redis cache: %v", err)
+	}
+	const key = "GET|example.com|/artifact.tar.gz"
+	chunks := func() []string {
+		var keys []string
+		for _, k := range srv.Keys() {
+			if strings.HasPrefix(k, key+"#chunk:") {
+				keys = append(keys, k)
+			}
+		}
+		return keys
+	}
+
+	c.Set(key, cache.CacheEntry{StatusCode: 200, Body: bytes.Repeat([]byte("a"), 500*1024), ExpiresAt: time.Now().Add(time.Minute)})
+	first := chunks()
+
+	smaller := bytes.Repeat([]byte("b"), 150*1024) // 3 chunks instead of 8
+	c.Set(key, cache.CacheEntry{StatusCode: 200, Body: smaller, ExpiresAt: time.Now().Add(time.Minute)})
+	if entry, found := c.Get(key); !found || !bytes.Equal(entry.Body, smaller) {
+		t.Fatal("expected the overwritten entry to be reassembled intact")
+	}
+	if got := chunks(); len(got) != 3 {
+		t.Errorf("expected only the 3 chunks of the new write, got %v", got)
+	}
+	for _, k := range first {
+		if srv.Exists(k) {
+			t.Errorf("expected chunk %q of the previous write to be deleted", k)
+		}
+	}
+
+	c.Set(key, cache.CacheEntry{StatusCode: 200, Body: []byte("inline"), ExpiresAt: time.Now().Add(time.Minute)})
+	if entry, found := c.Get(key); !found || string(entry.Body) != "inline" {
+		t.Fatal("expected the entry to be overwritten by an inline body")
+	}
+	if got := chunks(); len(got) != 0 {
+		t.Errorf("expected no chunks to remain after an inline overwrite, got %v", got)
+	}
+}
@@ -0,0 +1,111 @@
+// File: test/route_test.go
+package test
+
+import (
+	"go-caching-proxy/internal/cache"
+	"go-caching-proxy/internal/config"
+	"go-caching-proxy/internal/proxy"
+	"go-caching-proxy/internal/route"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRouteMatching checks host, glob, regex and method criteria and that
+// routes are evaluated in order.
+func TestRouteMatching(t *testing.T) {
+	routes, err := route.New([]config.Route{
+		{Name: "internal", Host: "*.internal.example.com"},
+		{Name: "releases", PathGlob: "/repos/*/*/releases/**", Methods: []string{"get"}},
+		{Name: "repo", PathRegex: `^/repos/[^/]+/[^/]+$`},
+		{Name: "api", PathPrefix: "/api/"},
+	}, config.KeyConfig{})
+	if err != nil {
+		t.Fatalf("failed to build routes: %v", err)
+	}
+
+	for _, tc := range []struct {
+		method, host, path, want string
+	}{
+		{"GET", "news.internal.example.com:8080", "/api/x", "internal"},
+		{"GET", "example.com", "/repos/a/b/releases/tags/v1", "releases"},
+		{"POST", "example.com", "/repos/a/b/releases/tags/v1", ""},
+		{"GET", "example.com", "/repos/a/b", "repo"},
+		{"GET", "example.com", "/repos/a/b/issues", ""},
+		{"GET", "example.com", "/api/users", "api"},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		r.Host = tc.host
+		got := ""
+		if rt := routes.Match(r); rt != nil {
+			got = rt.Name
+		}
+		if got != tc.want {
+			t.Errorf("%s %s%s: expected route %q, got %q", tc.method, tc.host, tc.path, tc.want, got)
+		}
+	}
+}
+
+// TestRouteCacheRules verifies per-route TTLs, bypass and force_cache.
+func TestRouteCacheRules(t *testing.T) {
+	var originHits int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&originHits, 1)
+		w.Header().Set("Cache-Control", "private")
+		w.Header().Set("Set-Cookie", "session=abc")
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	routes, err := route.New([]config.Route{
+		{Name: "live", PathPrefix: "/live", Bypass: true},
+		{Name: "forced", PathPrefix: "/forced", ForceCache: true, TTLSeconds: 3600},
+	}, config.KeyConfig{})
+	if err != nil {
+		t.Fatalf("failed to build routes: %v", err)
+	}
+	c := cache.NewLRUCache(10)
+	h, err := proxy.NewHandler(origin.URL, c, time.Minute, testLogger(), testMetrics(), proxy.WithRoutes(routes))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for _, path := range []string{"/live", "/other", "/forced"} {
+		get(t, srv.URL+path, nil)
+		resp, _ := get(t, srv.URL+path, nil)
+		if path == "/forced" && resp.Header.Get("Set-Cookie") != "" {
+			t.Error("expected Set-Cookie to be dropped from the forced entry")
+		}
+	}
+	// /live bypasses, /other is private: 2 hits each. /forced is cached.
+	if hits := atomic.LoadInt32(&originHits); hits != 5 {
+		t.Errorf("expected 5 origin hits, got %d", hits)
+	}
+
+	entry, ok := c.Get("GET|" + srv.Listener.Addr().String() + "|/forced")
+	if !ok || time.Until(entry.ExpiresAt) < 59*time.Minute {
+		t.Errorf("expected the forced entry to use the route's TTL, got %+v", entry)
+	}
+}
+
+// TestExampleConfigs makes sure the shipped example configurations load and
+// their route tables compile.
+func TestExampleConfigs(t *testing.T) {
+	for _, path := range []string{"../examples/github_api.yaml", "../examples/news_api.yaml", "../configs/config.yaml"} {
+		cfg, err := config.Load(path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if len(cfg.Routes) == 0 {
+			t.Errorf("%s: expected routes", path)
+		}
+		if _, err := route.New(cfg.Routes, cfg.Cache.Key); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,1056 @@
+// File: test/upstream_test.go
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"go-caching-proxy/internal/admin"
+	"go-caching-proxy/internal/cache"
+	"go-caching-proxy/internal/config"
+	"go-caching-proxy/internal/key"
+	"go-caching-proxy/internal/proxy"
+	"go-caching-proxy/internal/upstream"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestUpstreamRouting verifies that requests are routed to named upstreams by
+// Host header and by path prefix (with stripping), fall back to the default
+// upstream, and are cached in each upstream's own namespace.
+func TestUpstreamRouting(t *testing.T) {
+	newOrigin := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + r.Host + " " + r.URL.Path))
+		}))
+	}
+	github, news, def := newOrigin("github"), newOrigin("news"), newOrigin("default")
+	defer github.Close()
+	defer news.Close()
+	defer def.Close()
+
+	encoder, _ := key.NewEncoder("gocache", 1, key.HashNone)
+	set, err := upstream.NewSet([]config.Upstream{
+		{Name: "github", Target: github.URL, Hosts: []string{"github.proxy.test"}, Namespace: "gh"},
+		{Name: "news", Target: news.URL, PathPrefix: "/news", StripPrefix: true},
+	}, config.Upstream{Target: def.URL}, encoder)
+	if err != nil {
+		t.Fatalf("failed to build upstreams: %v", err)
+	}
+
+	c := cache.NewLRUCache(10)
+	h, err := proxy.NewHandler("", c, time.Minute, testLogger(), testMetrics(),
+		proxy.WithKeyEncoder(encoder), proxy.WithUpstreams(set))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		host, path, want string
+	}{
+		{"github.proxy.test", "/repos/a/b", "github " + strings.TrimPrefix(github.URL, "http://") + " /repos/a/b"},
+		{"", "/news/v2/top-headlines", "news " + strings.TrimPrefix(news.URL, "http://") + " /v2/top-headlines"},
+		{"", "/newsletter", "default " + strings.TrimPrefix(srv.URL, "http://") + " /newsletter"},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+tc.path, nil)
+		if tc.host != "" {
+			req.Host = tc.host
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		body := new(strings.Builder)
+		_, _ = io.Copy(body, resp.Body)
+		resp.Body.Close()
+		if body.String() != tc.want {
+			t.Errorf("%s%s: expected %q, got %q", tc.host, tc.path, tc.want, body.String())
+		}
+	}
+
+	keys := c.Keys("")
+	for _, prefix := range []string{"gh:v1:GET|github.proxy.test|/repos/a/b", "gocache:v1:GET|"} {
+		found := false
+		for _, k := range keys {
+			found = found || strings.HasPrefix(k, prefix)
+		}
+		if !found {
+			t.Errorf("expected a key starting with %q, got %v", prefix, keys)
+		}
+	}
+
+	// Bumping the shared generation invalidates every namespace.
+	encoder.Bump()
+	if got := h.Encoders()["github"].Prefix(); got != "gh:v2:" {
+		t.Errorf("expected the github namespace to follow the generation, got %q", got)
+	}
+}
+
+// TestDefaultUpstreamConfig verifies that the default upstream under proxy:
+// takes the same settings as a named one.
+func TestDefaultUpstreamConfig(t *testing.T) {
+	var calls int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "k-123" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := "proxy:\n" +
+		"  backends:\n" +
+		"    - url: " + origin.URL + "\n" +
+		"  retry:\n" +
+		"    max_retries: 1\n" +
+		"  credentials:\n" +
+		"    headers:\n" +
+		"      X-Api-Key: k-123\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	encoder, _ := key.NewEncoder("gocache", 1, key.HashNone)
+	set, err := upstream.NewSet(nil, cfg.Proxy.Upstream, encoder)
+	if err != nil {
+		t.Fatalf("failed to build upstreams: %v", err)
+	}
+	h, err := proxy.NewHandler("", cache.NewLRUCache(10), time.Minute, testLogger(), testMetrics(),
+		proxy.WithKeyEncoder(encoder), proxy.WithUpstreams(set))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	if resp, body := get(t, srv.URL+"/data", nil); resp.StatusCode != http.StatusOK || body != "ok" {
+		t.Errorf("expected the default upstream to add credentials and retry the 503, got %d %q", resp.StatusCode, body)
+	}
+
+	routed := cfg.Proxy.Upstream
+	routed.Hosts = []string{"api.example.com"}
+	if _, err := upstream.NewSet(nil, routed, encoder); err == nil {
+		t.Error("expected hosts on the default upstream to be rejected")
+	}
+}
+
+// TestLoadBalancing verifies the balancing strategies and that ejected
+// backends are skipped.
+func TestLoadBalancing(t *testing.T) {
+	var hits [3]atomic.Int64
+	var backends []config.Backend
+	for i := range hits {
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i].Add(1)
+			w.Write([]byte("ok"))
+		}))
+		defer origin.Close()
+		backends = append(backends, config.Backend{URL: origin.URL, Weight: 1})
+	}
+	backends[0].Weight = 2
+
+	encoder, _ := key.NewEncoder("gocache", 1, key.HashNone)
+	newUpstream := func(balance string) *upstream.Upstream {
+		set, err := upstream.NewSet([]config.Upstream{
+			{Name: "api", Backends: backends, Balance: balance, PathPrefix: "/"},
+		}, config.Upstream{}, encoder)
+		if err != nil {
+			t.Fatalf("failed to build upstreams: %v", err)
+		}
+		up, _ := set.Get("api")
+		return up
+	}
+	counts := func(up *upstream.Upstream, n int, key func(i int) string) map[string]int {
+		picked := make(map[string]int)
+		for i := range n {
+			picked[up.Pick(key(i)).Name()]++
+		}
+		return picked
+	}
+	anyKey := func(int) string { return "" }
+
+	up := newUpstream(upstream.RoundRobin)
+	for name, n := range counts(up, 9, anyKey) {
+		if n != 3 {
+			t.Errorf("round robin: expected 3 picks of %s, got %d", name, n)
+		}
+	}
+	up.Backends[1].SetHealthy(false)
+	if picked := counts(up, 6, anyKey); picked[up.Backends[1].Name()] != 0 || len(picked) != 2 {
+		t.Errorf("round robin: expected the ejected backend to be skipped, got %v", picked)
+	}
+
+	up = newUpstream(upstream.Weighted)
+	if picked := counts(up, 8, anyKey); picked[up.Backends[0].Name()] != 4 {
+		t.Errorf("weighted: expected half the picks on the weight-2 backend, got %v", picked)
+	}
+
+	up = newUpstream(upstream.ConsistentHash)
+	byKey := func(i int) string { return "GET|example.com|/item/" + strconv.Itoa(i) }
+	before := make([]string, 50)
+	for i := range before {
+		before[i] = up.Pick(byKey(i)).Name()
+		if again := up.Pick(byKey(i)).Name(); again != before[i] {
+			t.Fatalf("consistent hash: key %d moved from %s to %s", i, before[i], again)
+		}
+	}
+	ejected := up.Backends[2]
+	ejected.SetHealthy(false)
+	for i := range before {
+		got := up.Pick(byKey(i)).Name()
+		switch {
+		case got == ejected.Name():
+			t.Errorf("consistent hash: key %d still sent to the ejected backend", i)
+		case before[i] != ejected.Name() && got != before[i]:
+			t.Errorf("consistent hash: key %d moved from %s to %s although its backend is healthy", i, before[i], got)
+		}
+	}
+
+	// Every backend ejected: requests still go somewhere.
+	for _, b := range up.Backends {
+		b.SetHealthy(false)
+	}
+	if up.Pick("k") == nil {
+		t.Error("expected a backend even when all are unhealthy")
+	}
+
+	// Through the proxy, each miss reaches one backend and hits stay cached.
+	set, _ := upstream.NewSet([]config.Upstream{{Name: "api", Backends: backends, PathPrefix: "/"}}, config.Upstream{}, encoder)
+	h, err := proxy.NewHandler("", cache.NewLRUCache(10), time.Minute, testLogger(), testMetrics(),
+		proxy.WithKeyEncoder(encoder), proxy.WithUpstreams(set))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	for i := range hits {
+		hits[i].Store(0)
+	}
+	for i := range 6 {
+		get(t, srv.URL+"/item/"+strconv.Itoa(i%3), nil)
+	}
+	for i := range hits {
+		if n := hits[i].Load(); n != 1 {
+			t.Errorf("expected backend %d to serve one miss, got %d", i, n)
+		}
+	}
+}
+
+// TestHealthChecks verifies that failing active probes and consecutive live
+// failures take a backend out of rotation, and that both are reported.
+func TestHealthChecks(t *testing.T) {
+	var probeStatus atomic.Int64
+	probeStatus.Store(http.StatusOK)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(int(probeStatus.Load()))
+			return
+		}
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer flaky.Close()
+	steady := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer steady.Close()
+
+	cfg := config.Upstream{
+		Name:       "api",
+		PathPrefix: "/",
+		Backends:   []config.Backend{{URL: flaky.URL}, {URL: steady.URL}},
+	}
+	cfg.HealthCheck.Active.Path = "/healthz"
+	cfg.HealthCheck.Active.IntervalMS = 10
+	cfg.HealthCheck.Active.HealthyThreshold = 1
+	cfg.HealthCheck.Active.UnhealthyThreshold = 2
+	cfg.HealthCheck.Passive.ConsecutiveFailures = 2
+	encoder, _ := key.NewEncoder("gocache", 1, key.HashNone)
+	set, err := upstream.NewSet([]config.Upstream{cfg}, config.Upstream{}, encoder)
+	if err != nil {
+		t.Fatalf("failed to build upstreams: %v", err)
+	}
+	up, _ := set.Get("api")
+	bad := up.Backends[0]
+
+	checker := upstream.NewHealthChecker(set, testLogger(), testMetrics())
+	checker.Start()
+	defer checker.Stop()
+
+	h, err := proxy.NewHandler("", cache.NewLRUCache(10), time.Minute, testLogger(), testMetrics(),
+		proxy.WithKeyEncoder(encoder), proxy.WithUpstreams(set))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	health := httptest.NewServer(admin.UpstreamHealthHandler(h))
+	defer health.Close()
+	healthStatus := func() string {
+		_, body := get(t, health.URL, nil)
+		var v struct{ Status string }
+		json.Unmarshal([]byte(body), &v)
+		return v.Status
+	}
+
+	// Active: failing probes take the backend out, passing ones bring it back.
+	probeStatus.Store(http.StatusServiceUnavailable)
+	waitFor(t, "the probes to fail", bad.Failing)
+	if bad.Healthy() || healthStatus() != "degraded" {
+		t.Errorf("expected a failing backend to be out of rotation and upstream health degraded")
+	}
+	for range 4 {
+		if up.Pick("") == bad {
+			t.Fatal("expected the failing backend not to be picked")
+		}
+	}
+	probeStatus.Store(http.StatusOK)
+	waitFor(t, "the probes to pass", func() bool { return bad.Healthy() })
+	if s := healthStatus(); s != "ok" {
+		t.Errorf("expected upstream health ok, got %q", s)
+	}
+
+	// Passive: two 500s in a row eject the backend; live traffic then only
+	// reaches the other one.
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	for i := range 4 {
+		get(t, srv.URL+"/item/"+strconv.Itoa(i), nil)
+	}
+	if !bad.Ejected() {
+		t.Fatal("expected consecutive 500s to eject the backend")
+	}
+	for i := range 4 {
+		if resp, _ := get(t, srv.URL+"/other/"+strconv.Itoa(i), nil); resp.StatusCode != http.StatusOK {
+			t.Errorf("expected the ejected backend to be skipped, got %d", resp.StatusCode)
+		}
+	}
+
+	adminSrv := httptest.NewServer(admin.NewAPI(cache.NewLRUCache(1), h, testLogger()).Handler())
+	defer adminSrv.Close()
+	_, body := get(t, adminSrv.URL+"/api/upstreams", nil)
+	if !strings.Contains(body, `"ejected_until"`) || !strings.Contains(body, `"healthy":false`) {
+		t.Errorf("expected the admin API to report the ejection, got %s", body)
+	}
+}
+
+// TestExplainDryRun verifies that the explain endpoint's fetch is sent once,
+// doesn't count towards the backend's health or circuit breaker, and isn't
+// sent while the upstream's quota is low.
+func TestExplainDryRun(t *testing.T) {
+	var hits, remaining atomic.Int32
+	remaining.Store(100)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(remaining.Load())))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer origin.Close()
+
+	cfg := config.Upstream{Name: "api", Target: origin.URL, PathPrefix: "/",
+		Retry: config.Retry{MaxRetries: 2}, Quota: config.Quota{MinRemaining: 10}}
+	cfg.HealthCheck.Passive.ConsecutiveFailures = 1
+	cfg.CircuitBreaker = config.CircuitBreaker{ErrorRate: 0.5, MinRequests: 1}
+	encoder, _ := key.NewEncoder("gocache", 1, key.HashNone)
+	set, err := upstream.NewSet([]config.Upstream{cfg}, config.Upstream{}, encoder)
+	if err != nil {
+		t.Fatalf("failed to build upstreams: %v", err)
+	}
+	up, _ := set.Get("api")
+	h, err := proxy.NewHandler("", cache.NewLRUCache(10), time.Minute, testLogger(), testMetrics(),
+		proxy.WithKeyEncoder(encoder), proxy.WithUpstreams(set))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+
+	ex := h.Explain(httptest.NewRequest(http.MethodGet, "/item", nil), true)
+	if ex.Fetch == nil || ex.Fetch.StatusCode != http.StatusServiceUnavailable || hits.Load() != 1 {
+		t.Fatalf("expected a single fetch answered 503, got %d fetches and %+v", hits.Load(), ex.Fetch)
+	}
+	if up.Backends[0].Ejected() || up.Breaker.State().String() != "closed" {
+		t.Errorf("expected the dry run not to eject the backend or trip the breaker (breaker %s)", up.Breaker.State())
+	}
+
+	// The dry run still learns the quota, and stops spending it once low.
+	remaining.Store(3)
+	h.Explain(httptest.NewRequest(http.MethodGet, "/item", nil), true)
+	ex = h.Explain(httptest.NewRequest(http.MethodGet, "/item", nil), true)
+	if hits.Load() != 2 || ex.Fetch == nil || ex.Fetch.Error == "" {
+		t.Errorf("expected no fetch while the quota is low, got %d fetches and %+v", hits.Load(), ex.Fetch)
+	}
+}
+
+// TestRetriesAndHedging verifies retries on connection errors and retryable
+// statuses, the limits on them, and hedging of slow requests.
+func TestRetriesAndHedging(t *testing.T) {
+	encoder, _ := key.NewEncoder("gocache", 1, key.HashNone)
+	serve := func(cfg config.Upstream) string {
+		t.Helper()
+		cfg.Name, cfg.PathPrefix = "api", "/"
+		set, err := upstream.NewSet([]config.Upstream{cfg}, config.Upstream{}, encoder)
+		if err != nil {
+			t.Fatalf("failed to build upstreams: %v", err)
+		}
+		h, err := proxy.NewHandler("", cache.NewLRUCache(100), time.Minute, testLogger(), testMetrics(),
+			proxy.WithKeyEncoder(encoder), proxy.WithUpstreams(set))
+		if err != nil {
+			t.Fatalf("failed to create proxy handler: %v", err)
+		}
+		srv := httptest.NewServer(h)
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+
+	// Two 503s, then success: three attempts reach the origin.
+	var hits atomic.Int64
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= 2 || r.Method == http.MethodPost {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer flaky.Close()
+	retry := config.Retry{MaxRetries: 3, BackoffMS: 1, MaxBackoffMS: 5}
+	proxyURL := serve(config.Upstream{Target: flaky.URL, Retry: retry})
+	if resp, body := get(t, proxyURL+"/a", nil); resp.StatusCode != http.StatusOK || hits.Load() != 3 {
+		t.Errorf("expected success after two retries, got %d %q after %d attempts", resp.StatusCode, body, hits.Load())
+	}
+
+	// POST is not idempotent and is never retried.
+	hits.Store(10)
+	resp, err := http.Post(proxyURL+"/a", "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || hits.Load() != 11 {
+		t.Errorf("expected one POST attempt, got %d attempts", hits.Load()-10)
+	}
+
+	// A backend refusing connections is retried on the next one.
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("good")) }))
+	defer good.Close()
+	proxyURL = serve(config.Upstream{Backends: []config.Backend{{URL: dead.URL}, {URL: good.URL}}, Retry: retry})
+	for i := range 2 {
+		if _, body := get(t, proxyURL+"/b/"+strconv.Itoa(i), nil); body != "good" {
+			t.Errorf("expected the connection error to be retried, got %q", body)
+		}
+	}
+
+	// The budget allows min retries plus a fraction of requests.
+	hits.Store(0)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer down.Close()
+	budget := retry
+	budget.MaxRetries, budget.BudgetRatio, budget.BudgetMinRetries = 5, 0.01, 1
+	proxyURL = serve(config.Upstream{Target: down.URL, Retry: budget})
+	get(t, proxyURL+"/c", nil)
+	get(t, proxyURL+"/d", nil)
+	if n := hits.Load(); n != 3 {
+		t.Errorf("expected one retry in the budget (3 attempts), got %d attempts", n)
+	}
+
+	// Hedging: once response times are known, a request stuck for longer
+	// than their 90th percentile is sent again, and the second one wins.
+	var slowOnce atomic.Bool
+	release := make(chan struct{})
+	defer close(release)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" && slowOnce.CompareAndSwap(false, true) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Write([]byte("fast"))
+	}))
+	defer slow.Close()
+	proxyURL = serve(config.Upstream{Target: slow.URL, Hedge: config.Hedge{Percentile: 90, MinDelayMS: 20}})
+	for i := range 25 {
+		get(t, proxyURL+"/warm/"+strconv.Itoa(i), nil)
+	}
+	start := time.Now()
+	if _, body := get(t, proxyURL+"/slow", nil); body != "fast" {
+		t.Errorf("expected the hedged request to win, got %q", body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the hedged request to answer quickly, took %v", elapsed)
+	}
+}
+
+// TestCircuitBreaker verifies that a failing upstream trips its breaker,
+// that requests are then answered from stale entries or the fallback without
+// reaching the origin, and that the breaker closes once the origin recovers.
+func TestCircuitBreaker(t *testing.T) {
+	var down atomic.Bool
+	var hits atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if down.Load() {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("fresh " + r.URL.Path))
+	}))
+	defer origin.Close()
+
+	cfg := config.Upstream{Name: "api", PathPrefix: "/", Target: origin.URL}
+	cfg.CircuitBreaker = config.CircuitBreaker{
+		ErrorRate:        0.5,
+		MinRequests:      5, // The first good request and four failures
+		OpenSeconds:      1,
+		HalfOpenRequests: 1,
+		Fallback:         config.Fallback{Status: http.StatusServiceUnavailable, Body: "try later"},
+	}
+	encoder, _ := key.NewEncoder("gocache", 1, key.HashNone)
+	set, err := upstream.NewSet([]config.Upstream{cfg}, config.Upstream{}, encoder)
+	if err != nil {
+		t.Fatalf("failed to build upstreams: %v", err)
+	}
+	up, _ := set.Get("api")
+	c := cache.NewLRUCache(100)
+	h, err := proxy.NewHandler("", c, 10*time.Millisecond, testLogger(), testMetrics(),
+		proxy.WithKeyEncoder(encoder), proxy.WithUpstreams(set), proxy.WithStale(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	get(t, srv.URL+"/page", nil)
+	waitFor(t, "the entry to go stale", func() bool {
+		entry, ok := c.Get("gocache:v1:GET|" + srv.Listener.Addr().String() + "|/page")
+		return ok && !entry.Fresh(time.Now())
+	})
+
+	down.Store(true)
+	for i := range 4 {
+		if resp, _ := get(t, srv.URL+"/fail/"+strconv.Itoa(i), nil); resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("expected the origin's 500 while the breaker is closed, got %d", resp.StatusCode)
+		}
+	}
+	if s := up.Breaker.State(); s != upstream.Open {
+		t.Fatalf("expected the breaker to open, got %s", s)
+	}
+
+	before := hits.Load()
+	if resp, body := get(t, srv.URL+"/page", nil); resp.StatusCode != http.StatusOK || body != "fresh /page" {
+		t.Errorf("expected the stale entry while open, got %d %q", resp.StatusCode, body)
+	}
+	resp, body := get(t, srv.URL+"/new", nil)
+	if resp.StatusCode != http.StatusServiceUnavailable || body != "try later" || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("expected the fallback with Retry-After while open, got %d %q", resp.StatusCode, body)
+	}
+	if hits.Load() != before {
+		t.Errorf("expected no origin requests while open, got %d", hits.Load()-before)
+	}
+
+	// After open_seconds, one good probe closes the breaker.
+	down.Store(false)
+	waitFor(t, "the breaker's open period to end", func() bool { return up.Breaker.RetryAfter() == 0 })
+	if _, body := get(t, srv.URL+"/new", nil); body != "fresh /new" {
+		t.Errorf("expected the half-open probe to reach the origin, got %q", body)
+	}
+	if s := up.Breaker.State(); s != upstream.Closed {
+		t.Errorf("expected the breaker to close after a good probe, got %s", s)
+	}
+}
+
+// TestUpstreamTransport verifies the TLS and HTTP/2 transport settings
+// against an origin that requires a client certificate and whose own
+// certificate is only valid for a name the proxy doesn't connect by.
+func TestUpstreamTransport(t *testing.T) {
+	pki := newTestPKI(t)
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	origin.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.pool,
+	}
+	origin.EnableHTTP2 = true
+	origin.Config.ErrorLog = log.New(io.Discard, "", 0) // Failed handshakes are expected.
+	origin.StartTLS()
+	defer origin.Close()
+
+	mTLS := config.TLS{CAFile: pki.caFile, CertFile: pki.certFile, KeyFile: pki.keyFile, ServerName: "origin.internal"}
+	encoder, _ := key.NewEncoder("gocache", 1, key.HashNone)
+	for _, tc := range []struct {
+		name      string
+		transport config.Transport
+		status    int
+		proto     string
+	}{
+		{"mTLS with SNI override", config.Transport{TLS: mTLS}, http.StatusOK, "HTTP/2.0"},
+		{"HTTP/2 disabled", config.Transport{TLS: mTLS, DisableHTTP2: true}, http.StatusOK, "HTTP/1.1"},
+		{"no client certificate", config.Transport{TLS: config.TLS{CAFile: pki.caFile, ServerName: "origin.internal"}}, http.StatusBadGateway, ""},
+		{"name mismatch", config.Transport{TLS: config.TLS{CAFile: pki.caFile, CertFile: pki.certFile, KeyFile: pki.keyFile}}, http.StatusBadGateway, ""},
+		{"verification skipped", config.Transport{TLS: config.TLS{CertFile: pki.certFile, KeyFile: pki.keyFile, InsecureSkipVerify: true}}, http.StatusOK, "HTTP/2.0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.transport.DialTimeoutMS = 1000
+			set, err := upstream.NewSet(nil, config.Upstream{Target: origin.URL, Transport: tc.transport}, encoder)
+			if err != nil {
+				t.Fatalf("failed to build upstreams: %v", err)
+			}
+			h, err := proxy.NewHandler("", cache.NewLRUCache(10), time.Minute, testLogger(), testMetrics(),
+				proxy.WithKeyEncoder(encoder), proxy.WithUpstreams(set))
+			if err != nil {
+				t.Fatalf("failed to create proxy handler: %v", err)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/proto", nil))
+			if w.Code != tc.status || (tc.proto != "" && w.Body.String() != tc.proto) {
+				t.Errorf("expected %d %q, got %d %q", tc.status, tc.proto, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if _, err := upstream.NewSet(nil, config.Upstream{Target: origin.URL, Transport: config.Transport{TLS: config.TLS{CertFile: pki.certFile}}}, encoder); err == nil {
+		t.Error("expected a certificate without a key to be rejected")
+	}
+}
+
+// testPKI is a CA with a server certificate for "origin.internal" and a
+// client certificate, the CA and client files written to a temp dir.
+type testPKI struct {
+	pool                      *x509.CertPool
+	server                    tls.Certificate
+	caFile, certFile, keyFile string
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	t.Helper()
+	dir := t.TempDir()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	issue := func(serial int64, tmpl *x509.Certificate) ([]byte, *ecdsa.PrivateKey) {
+		k, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tmpl.SerialNumber = big.NewInt(serial)
+		tmpl.NotBefore, tmpl.NotAfter = caTmpl.NotBefore, caTmpl.NotAfter
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &k.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("failed to issue certificate: %v", err)
+		}
+		return der, k
+	}
+	pemFile := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		return path
+	}
+
+	serverDER, serverKey := issue(2, &x509.Certificate{
+		DNSNames:    []string{"origin.internal"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	clientDER, clientKey := issue(3, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "proxy"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	clientKeyDER, _ := x509.MarshalECPrivateKey(clientKey)
+
+	pki := testPKI{
+		pool:     x509.NewCertPool(),
+		server:   tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey},
+		caFile:   pemFile("ca.pem", "CERTIFICATE", caDER),
+		certFile: pemFile("client.pem", "CERTIFICATE", clientDER),
+		keyFile:  pemFile("client-key.pem", "EC PRIVATE KEY", clientKeyDER),
+	}
+	pki.pool.AddCert(ca)
+	return pki
+}
+
+// TestUpstreamCredentials verifies that an upstream's credentials replace the
+// client's on the way to the origin and show up in neither responses, cached
+// headers nor cache keys.
+func TestUpstreamCredentials(t *testing.T) {
+	const token, apiKey, queryKey = "token-from-file-0123", "key-from-env-4567", "query-key-89ab"
+	var seen atomic.Value
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen.Store(r.Header.Get("Authorization") + " " + r.Header.Get("X-Api-Key") + " " + r.URL.RawQuery)
+		w.Header().Set("X-Echo-Key", r.Header.Get("X-Api-Key"))
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.URL.Path == "/moved" {
+			w.Header().Set("Location", "/new?page=2&apiKey="+r.URL.Query().Get("apiKey"))
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte(token+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_API_KEY", apiKey)
+	creds := config.Credentials{
+		BearerToken: config.Secret{File: tokenFile},
+		Headers:     map[string]config.Secret{"X-Api-Key": {Env: "TEST_API_KEY"}},
+		Query:       map[string]config.Secret{"apiKey": {Value: queryKey}},
+	}
+
+	encoder, _ := key.NewEncoder("gocache", 1, key.HashNone)
+	set, err := upstream.NewSet([]config.Upstream{
+		{Name: "api", Target: origin.URL, PathPrefix: "/", Credentials: creds},
+	}, config.Upstream{}, encoder)
+	if err != nil {
+		t.Fatalf("failed to build upstreams: %v", err)
+	}
+	c := cache.NewLRUCache(10)
+	h, err := proxy.NewHandler("", c, time.Minute, testLogger(), testMetrics(),
+		proxy.WithKeyEncoder(encoder), proxy.WithUpstreams(set))
+	if err != nil {
+		t.Fatalf("failed to create proxy handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, _ := get(t, srv.URL+"/items?q=1", map[string]string{"Authorization": "Bearer client-token"})
+	want := "Bearer " + token + " " + apiKey + " apiKey=" + queryKey + "&q=1"
+	if got := seen.Load(); got != want {
+		t.Errorf("expected the origin to see %q, got %q", want, got)
+	}
+	if got := resp.Header.Get("X-Echo-Key"); got != "" {
+		t.Errorf("expected the echoed credential to be removed, got %q", got)
+	}
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err = noRedirect.Get(srv.URL + "/moved")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Location"); got != "/new?page=2" {
+		t.Errorf("expected the injected query parameter to be removed from Location, got %q", got)
+	}
+
+	for _, k := range c.Keys("") {
+		for _, secret := range []string{token, apiKey, queryKey} {
+			if strings.Contains(k, secret) {
+				t.Errorf("cache key %q contains a credential", k)
+			}
+		}
+		entry, _ := c.Get(k)
+		for name, values := range entry.Headers {
+			for _, v := range values {
+				if strings.Contains(v, apiKey) || strings.Contains(v, queryKey) {
+					t.Errorf("cached header %s: %q contains a credential", name, v)
+				}
+			}
+		}
+	}
+
+	// With in_key, the key carries a hash of the credentials instead.
+	creds.InKey = true
+	keyed, err := upstream.NewSet([]config.Upstream{
+		{Name: "api", Target: origin.URL, PathPrefix: "/", Credentials: creds},
+	}, config.Upstream{}, encoder)
+	if err != nil {
+		t.Fatalf("failed to build upstreams: %v", err)
+	}
+	up, _ := keyed.Get("api")
+	if suffix := up.Credentials.KeySuffix(); !strings.HasPrefix(suffix, "|cred:") || strings.Contains(suffix, token) {
+		t.Errorf("expected a hashed credentials key suffix, got %q", suffix)
+	}
+
+	// A credential whose source is missing stops the proxy from starting.
+	creds.Headers = map[string]config.Secret{"X-Api-Key": {Env: "TEST_MISSING_API_KEY"}}
+	if _, err := upstream.NewSet([]config.Upstream{
+		{Name: "api", Target: origin.URL, PathPrefix: "/", Credentials: creds},
+	}, config.Upstream{}, encoder); err == nil {
+		t.Error("expected an error for an unset environment variable")
+	}
+}
+
+// TestUpstreamOAuth2 verifies that access tokens from a client credentials
+// token endpoint are cached, shared by concurrent requests, and refreshed
+// once when the origin rejects them.
+func TestUpstreamOAuth2(t *testing.T) {
+	var issued, tokenRequests atomic.Int64
+	var tokenDown atomic.Bool
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests.Add(1)
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "proxy" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		if tokenDown.Load() {
+			http.Error(w, `{"error":"temporarily_unavailable"}`, http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(50 * time.Millisecond) // Let concurrent requests pile up
+		n := issued.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token-number-` + strconv.FormatInt(n, 10) + `","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	// The origin accepts only the token it is told to; moving it on is
+	// how the test revokes tokens.
+	var accepted atomic.Value
+	accepted.Store("Bearer token-number-1")
+	var originHits atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originHits.Add(1)
+		if r.Header.Get("Authorization") != accepted.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	encoder, _ := key.NewEncoder("gocache", 1, key.HashNone)
+	newProxy := func() *httptest.Server {
+		set, err := upstream.NewSet([]config.Upstream{{
+			Name: "api", Target: origin.URL, PathPrefix: "/",
+			Retry: config.Retry{MaxRetries: 2},
+			Credentials: config.Credentials{OAuth2: config.OAuth2{
+				TokenURL:     tokenServer.URL,
+				ClientID:     "proxy",
+				ClientSecret: config.Secret{Value: "s3cret"},
+				Scopes:       []string{"read", "write"},
+			}},
+		}}, config.Upstream{}, encoder)
+		if err != nil {
+			t.Fatalf("failed to build upstreams: %v", err)
+		}
+		negative, err := proxy.NewNegativeTTLs(nil, 60)
+		if err != nil {
+			t.Fatalf("failed to build negative TTLs: %v", err)
+		}
+		h, err := proxy.NewHandler("", cache.NewLRUCache(100), time.Minute, testLogger(), testMetrics(),
+			proxy.WithKeyEncoder(encoder), proxy.WithUpstreams(set), proxy.WithNegativeCaching(negative))
+		if err != nil {
+			t.Fatalf("failed to create proxy handler: %v", err)
+		}
+		srv := httptest.NewServer(h)
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	srv := newProxy()
+
+	burst := func(prefix string, n int) {
+		t.Helper()
+		statuses := make(chan int, n)
+		for i := range n {
+			go func() {
+				resp, err := http.Get(srv.URL + "/" + prefix + "/" + strconv.Itoa(i))
+				if err != nil {
+					statuses <- 0
+					return
+				}
+				resp.Body.Close()
+				statuses <- resp.StatusCode
+			}()
+		}
+		for range n {
+			if status := <-statuses; status != http.StatusOK {
+				t.Errorf("%s: expected 200, got %d", prefix, status)
+			}
+		}
+	}
+
+	// Concurrent first requests share one token request.
+	burst("first", 10)
+	if n := issued.Load(); n != 1 {
+		t.Errorf("expected 1 token request, got %d", n)
+	}
+	burst("cached", 5)
+	if n := issued.Load(); n != 1 {
+		t.Errorf("expected the token to be cached, got %d token requests", n)
+	}
+
+	// After revocation every request gets a 401 once, but the token is only
+	// refreshed once.
+	accepted.Store("Bearer token-number-2")
+	burst("revoked", 10)
+	if n := issued.Load(); n != 2 {
+		t.Errorf("expected 2 token requests after revocation, got %d", n)
+	}
+
+	// A token the origin keeps rejecting is retried once, then the 401 is
+	// passed on.
+	accepted.Store("never")
+	originHits.Store(0)
+	resp, _ := get(t, srv.URL+"/rejected", nil)
+	if resp.StatusCode != http.StatusUnauthorized || originHits.Load() != 2 {
+		t.Errorf("expected a 401 after 2 origin requests, got %d after %d", resp.StatusCode, originHits.Load())
+	}
+
+	// If the refresh fails, the origin's 401 is passed on; without any
+	// token, the origin isn't asked at all.
+	tokenDown.Store(true)
+	originHits.Store(0)
+	resp, _ = get(t, srv.URL+"/token-down", nil)
+	if resp.StatusCode != http.StatusUnauthorized || originHits.Load() != 1 {
+		t.Errorf("expected a 401 after 1 origin request, got %d after %d", resp.StatusCode, originHits.Load())
+	}
+	// Token failures are neither retried nor cached as origin errors.
+	fresh := newProxy().URL
+	tokenRequests.Store(0)
+	resp, _ = get(t, fresh+"/token-down", nil)
+	if resp.StatusCode != http.StatusBadGateway || originHits.Load() != 1 {
+		t.Errorf("expected a 502 without reaching the origin, got %d after %d origin requests", resp.StatusCode, originHits.Load())
+	}
+	if n := tokenRequests.Load(); n != 1 {
+		t.Errorf("expected the token failure not to be retried, got %d token requests", n)
+	}
+	tokenDown.Store(false)
+	accepted.Store("Bearer token-number-" + strconv.FormatInt(issued.Load()+1, 10))
+	if resp, body := get(t, fresh+"/token-down", nil); resp.StatusCode != http.StatusOK || body != "ok" {
+		t.Errorf("expected the token failure not to be cached, got %d %q", resp.StatusCode, body)
+	}
+}
+
+// TestUpstreamQuota verifies that an upstream whose rate-limit quota runs low
+// is protected: stale entries are served, misses are rejected or paced, and
+// a 429's Retry-After is respected.
+func TestUpstreamQuota(t *testing.T) {
+	var remaining, originHits atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originHits.Add(1)
+		w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(remaining.Load(), 10))
+		w.Header().Set("X-RateLimit-Reset", "1") // Seconds from now
+		if r.URL.Path == "/limited" {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok " + r.URL.Path))
+	}))
+	defer origin.Close()
+
+	encoder, _ := key.NewEncoder("gocache", 1, key.HashNone)
+	newProxy := func(onMiss string) (*httptest.Server, *cache.LRUCache, *upstream.Upstream) {
+		set, err := upstream.NewSet([]config.Upstream{{
+			Name: "api", Target: origin.URL, PathPrefix: "/",
+			Quota: config.Quota{MinRemaining: 10, OnMiss: onMiss, QueueTimeoutMS: 2000},
+		}}, config.Upstream{}, encoder)
+		if err != nil {
+			t.Fatalf("failed to build upstreams: %v", err)
+		}
+		c := cache.NewLRUCache(100)
+		h, err := proxy.NewHandler("", c, 10*time.Millisecond, testLogger(), testMetrics(),
+			proxy.WithKeyEncoder(encoder), proxy.WithUpstreams(set), proxy.WithStale(time.Minute))
+		if err != nil {
+			t.Fatalf("failed to create proxy handler: %v", err)
+		}
+		srv := httptest.NewServer(h)
+		t.Cleanup(srv.Close)
+		up, _ := set.Get("api")
+		return srv, c, up
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		srv, c, up := newProxy("")
+		remaining.Store(100)
+		get(t, srv.URL+"/a", nil)
+		remaining.Store(5)
+		get(t, srv.URL+"/b", nil) // Reports the low quota
+		waitFor(t, "the entry to go stale", func() bool {
+			entry, ok := c.Get("gocache:v1:GET|" + srv.Listener.Addr().String() + "|/a")
+			return ok && !entry.Fresh(time.Now())
+		})
+
+		originHits.Store(0)
+		resp, body := get(t, srv.URL+"/a", nil)
+		if resp.StatusCode != http.StatusOK || body != "ok /a" {
+			t.Errorf("expected the stale entry, got %d %q", resp.StatusCode, body)
+		}
+		resp, _ = get(t, srv.URL+"/c", nil)
+		if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+			t.Errorf("expected a 429 with Retry-After for a miss, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+		}
+		if n := originHits.Load(); n != 0 {
+			t.Errorf("expected the origin to be spared, got %d requests", n)
+		}
+
+		// Once the limit resets, requests go through again.
+		waitFor(t, "the limit to reset", func() bool { return !up.Quota.Low() })
+		remaining.Store(100)
+		if resp, _ := get(t, srv.URL+"/c", nil); resp.StatusCode != http.StatusOK {
+			t.Errorf("expected 200 after the reset, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		srv, _, _ := newProxy("queue")
+		remaining.Store(2)
+		get(t, srv.URL+"/x", nil)
+
+		// Two requests are left for the next second: the first goes at
+		// once, the second about half a second later.
+		start := time.Now()
+		for _, path := range []string{"/y", "/z"} {
+			if resp, _ := get(t, srv.URL+path, nil); resp.StatusCode != http.StatusOK {
+				t.Errorf("%s: expected 200, got %d", path, resp.StatusCode)
+			}
+		}
+		if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+			t.Errorf("expected the second request to be paced, took %v", elapsed)
+		}
+
+		// A 429 blocks the upstream for its Retry-After, longer than the
+		// queue timeout.
+		get(t, srv.URL+"/limited", nil)
+		originHits.Store(0)
+		resp, _ := get(t, srv.URL+"/w", nil)
+		if resp.StatusCode != http.StatusTooManyRequests || originHits.Load() != 0 {
+			t.Errorf("expected a 429 without reaching the origin, got %d after %d requests", resp.StatusCode, originHits.Load())
+		}
+		if ra, _ := strconv.Atoi(resp.Header.Get("Retry-After")); ra < 25 || ra > 30 {
+			t.Errorf("expected Retry-After near 30, got %q", resp.Header.Get("Retry-After"))
+		}
+	})
+}